@@ -0,0 +1,132 @@
+package internal
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const bridgeStateTopic = "$SYS/broker/connection/+/state"
+
+// BridgeOptions configures the bridge collector.
+type BridgeOptions struct {
+	// StaleAfter drops the series of a bridge when its state was not
+	// published for this long. Zero keeps bridges forever. Mosquitto only
+	// publishes the state when it changes, so this only suits brokers that
+	// republish it, a stable bridge is dropped otherwise.
+	StaleAfter time.Duration `yaml:"stale_after"`
+}
+
+func (o BridgeOptions) validate() error {
+	if o.StaleAfter < 0 {
+		return errNegativeDuration("bridge.stale_after")
+	}
+	return nil
+}
+
+type bridgeState struct {
+	up          float64
+	transitions float64
+	lastSeen    time.Time
+}
+
+type BridgeCollector struct {
+	mu           sync.RWMutex
+	Bridges      map[string]*bridgeState
+	descriptions map[string]metric
+	staleAfter   time.Duration
+	now          func() time.Time
+}
+
+func init() {
+	registerCollector("bridge", false, "Enable the bridge collector.", func(labels prometheus.Labels, options CollectorOptions) Collector {
+		return NewBridgeCollector(labels, options.Bridge)
+	})
+}
+
+func NewBridgeCollector(labels prometheus.Labels, options BridgeOptions) *BridgeCollector {
+	return &BridgeCollector{
+		mu:      sync.RWMutex{},
+		Bridges: make(map[string]*bridgeState, 4),
+		descriptions: map[string]metric{
+			"up": {
+				desc:      prometheus.NewDesc("mosquitto_bridge_up", "Whether the bridge is connected (1 = up, 0 = down)", []string{"bridge"}, labels),
+				valueType: prometheus.GaugeValue,
+			},
+			"transitions": {
+				desc:      prometheus.NewDesc("mosquitto_bridge_state_transitions_total", "Number of state changes of the bridge seen by the exporter", []string{"bridge"}, labels),
+				valueType: prometheus.CounterValue,
+			},
+		},
+		staleAfter: options.StaleAfter,
+		now:        time.Now,
+	}
+}
+
+func (collector *BridgeCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range collector.descriptions {
+		ch <- desc.desc
+	}
+}
+
+func (collector *BridgeCollector) Collect(ch chan<- prometheus.Metric) {
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+
+	up := collector.descriptions["up"]
+	transitions := collector.descriptions["transitions"]
+	for name, state := range collector.Bridges {
+		if collector.staleAfter > 0 && collector.now().Sub(state.lastSeen) > collector.staleAfter {
+			delete(collector.Bridges, name)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(up.desc, up.valueType, state.up, name)
+		ch <- prometheus.MustNewConstMetric(transitions.desc, transitions.valueType, state.transitions, name)
+	}
+}
+
+func (collector *BridgeCollector) Subscribe(client Client) {
+	if err := client.Subscribe(bridgeStateTopic, 0, collector.stateHandler); err != nil {
+		log.Printf("Failed to subscribe to %s: %v", bridgeStateTopic, err)
+	}
+}
+
+func (collector *BridgeCollector) stateHandler(client Client, message Message) {
+	// Topic is '$SYS/broker/connection/<bridge>/state', payload is '0' or '1'
+	topic := topicLevels(message.Topic())
+	if len(topic) != 5 {
+		return
+	}
+	name := topic[3]
+	// The retained state is cleared when the bridge is removed
+	if len(message.Payload()) == 0 {
+		collector.mu.Lock()
+		delete(collector.Bridges, name)
+		collector.mu.Unlock()
+		return
+	}
+	num, err := strconv.Atoi(strings.TrimSpace(string(message.Payload())))
+	if err != nil {
+		return
+	}
+	up := float64(0)
+	if num > 0 {
+		up = 1
+	}
+
+	collector.mu.Lock()
+	state, ok := collector.Bridges[name]
+	if !ok {
+		state = &bridgeState{up: up}
+		collector.Bridges[name] = state
+	} else if state.up != up {
+		state.up = up
+		state.transitions++
+	}
+	state.lastSeen = collector.now()
+	collector.mu.Unlock()
+}
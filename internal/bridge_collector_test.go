@@ -0,0 +1,102 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewBridgeCollector(t *testing.T) {
+	labels := prometheus.Labels{"broker": "test-broker"}
+	collector := NewBridgeCollector(labels, BridgeOptions{})
+
+	assert.NotNil(t, collector)
+	assert.NotNil(t, collector.Bridges)
+	assert.Equal(t, 2, len(collector.descriptions))
+}
+
+func TestBridgeCollector_StateHandler(t *testing.T) {
+	labels := prometheus.Labels{"broker": "test-broker"}
+	collector := NewBridgeCollector(labels, BridgeOptions{})
+	client := newFakeClient("exporter")
+	collector.Subscribe(client)
+
+	for _, payload := range []string{"1", "0", "0", "1"} {
+		client.publish("$SYS/broker/connection/cloud/state", payload)
+	}
+	client.publish("$SYS/broker/connection/backup/state", "0")
+	// Invalid payloads and topics are ignored
+	client.publish("$SYS/broker/connection/cloud/state", "up")
+	client.publish("$SYS/broker/connection/state", "1")
+
+	require.Len(t, collector.Bridges, 2)
+	assert.Equal(t, float64(1), collector.Bridges["cloud"].up)
+	assert.Equal(t, float64(2), collector.Bridges["cloud"].transitions)
+	assert.Equal(t, float64(0), collector.Bridges["backup"].up)
+	assert.Equal(t, float64(0), collector.Bridges["backup"].transitions)
+
+	count, err := testutil.GatherAndCount(registryWith(t, collector), "mosquitto_bridge_up", "mosquitto_bridge_state_transitions_total")
+	require.NoError(t, err)
+	assert.Equal(t, 4, count)
+
+	// A removed bridge clears its retained state
+	client.publish("$SYS/broker/connection/backup/state", "")
+	assert.NotContains(t, collector.Bridges, "backup")
+	assert.Contains(t, collector.Bridges, "cloud")
+}
+
+func TestBridgeCollector_Staleness(t *testing.T) {
+	labels := prometheus.Labels{"broker": "test-broker"}
+	collector := NewBridgeCollector(labels, BridgeOptions{StaleAfter: time.Minute})
+	client := newFakeClient("exporter")
+	collector.Subscribe(client)
+	now := time.Now()
+	collector.now = func() time.Time { return now }
+
+	client.publish("$SYS/broker/connection/cloud/state", "1")
+	now = now.Add(30 * time.Second)
+	client.publish("$SYS/broker/connection/backup/state", "1")
+	now = now.Add(45 * time.Second)
+
+	registry := registryWith(t, collector)
+	count, err := testutil.GatherAndCount(registry, "mosquitto_bridge_up")
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.NotContains(t, collector.Bridges, "cloud")
+
+	assert.Error(t, BridgeOptions{StaleAfter: -time.Second}.validate())
+}
+
+// registryWith returns a registry holding only collector.
+func registryWith(t *testing.T, collector prometheus.Collector) *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	require.NoError(t, registry.Register(collector))
+	return registry
+}
+
+// gatherValues returns the values of the series of the metric name.
+func gatherValues(t *testing.T, registry *prometheus.Registry, name string) []float64 {
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	var values []float64
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			switch {
+			case m.GetGauge() != nil:
+				values = append(values, m.GetGauge().GetValue())
+			case m.GetCounter() != nil:
+				values = append(values, m.GetCounter().GetValue())
+			case m.GetUntyped() != nil:
+				values = append(values, m.GetUntyped().GetValue())
+			}
+		}
+	}
+	return values
+}
@@ -0,0 +1,495 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector is a prometheus.Collector fed by subscriptions on the broker.
+type Collector interface {
+	prometheus.Collector
+	Subscribe(client Client)
+}
+
+type collectorFactory func(labels prometheus.Labels, options CollectorOptions) Collector
+
+// collectorRegistration describes a collector that can be enabled by name.
+type collectorRegistration struct {
+	name string
+	// help is the description of its --collector.<name> flag, collectors
+	// that are only useful with a configuration file have none.
+	help           string
+	defaultEnabled bool
+	factory        collectorFactory
+}
+
+var collectorRegistry = make(map[string]collectorRegistration)
+
+// registerCollector makes a collector available under name. Collectors
+// register themselves from the init function of their file.
+func registerCollector(name string, defaultEnabled bool, help string, factory collectorFactory) {
+	if _, ok := collectorRegistry[name]; ok {
+		panic(fmt.Sprintf("collector %q registered twice", name))
+	}
+	collectorRegistry[name] = collectorRegistration{
+		name:           name,
+		help:           help,
+		defaultEnabled: defaultEnabled,
+		factory:        factory,
+	}
+}
+
+// validateCollectorNames checks that every collector in lists is registered.
+func validateCollectorNames(lists ...[]string) error {
+	for _, names := range lists {
+		for _, name := range names {
+			if _, ok := collectorRegistry[name]; !ok {
+				return fmt.Errorf("unknown collector %q", name)
+			}
+		}
+	}
+	return nil
+}
+
+// CollectorInfo describes a registered collector, for the command line.
+type CollectorInfo struct {
+	Name           string
+	Help           string
+	DefaultEnabled bool
+}
+
+// Collectors returns the registered collectors sorted by name.
+func Collectors() []CollectorInfo {
+	collectors := make([]CollectorInfo, 0, len(collectorRegistry))
+	for _, registration := range collectorRegistry {
+		collectors = append(collectors, CollectorInfo{
+			Name:           registration.name,
+			Help:           registration.help,
+			DefaultEnabled: registration.defaultEnabled,
+		})
+	}
+	sort.Slice(collectors, func(i, j int) bool {
+		return collectors[i].Name < collectors[j].Name
+	})
+	return collectors
+}
+
+// CollectorOptions holds the settings of the collectors that have some.
+type CollectorOptions struct {
+	Load        LoadOptions        `yaml:"load"`
+	Bridge      BridgeOptions      `yaml:"bridge"`
+	Connections ConnectionsOptions `yaml:"connections"`
+	Dynsec      DynsecOptions      `yaml:"dynsec"`
+	Control     ControlOptions     `yaml:"control"`
+	Roundtrip   RoundtripOptions   `yaml:"roundtrip"`
+	Persistence PersistenceOptions `yaml:"persistence"`
+	Topics      TopicsOptions      `yaml:"topics"`
+	Liveness    LivenessOptions    `yaml:"liveness"`
+	Payload     PayloadOptions     `yaml:"payload"`
+	Discovery   DiscoveryOptions   `yaml:"discovery"`
+	Sys         SysOptions         `yaml:"sys"`
+}
+
+func (o CollectorOptions) validate() error {
+	for _, validate := range []func() error{
+		o.Load.validate,
+		o.Bridge.validate,
+		o.Connections.validate,
+		o.Dynsec.validate,
+		o.Control.validate,
+		o.Roundtrip.validate,
+		o.Persistence.validate,
+		o.Topics.validate,
+		o.Liveness.validate,
+		o.Payload.validate,
+		o.Discovery.validate,
+		o.Sys.validate,
+	} {
+		if err := validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func errNegativeDuration(option string) error {
+	return fmt.Errorf("%s must not be negative", option)
+}
+
+// sortedLabelNames returns the names of the labels defined in the
+// configuration, sorted so the label values are always in the same order.
+func sortedLabelNames(labels map[string]string) []string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// mqttProtocolVersions maps the supported protocol versions to the values of
+// the MQTT 3 client, 0 letting it negotiate 3.1.1 or 3.1. Version 5 uses a
+// different client.
+var mqttProtocolVersions = map[string]uint{
+	"":      0,
+	"3.1":   3,
+	"3.1.1": 4,
+}
+
+// ModuleConfig holds the connection settings and collectors that do not
+// depend on the broker address, so they can be shared by probes.
+type ModuleConfig struct {
+	ClientID string    `yaml:"client_id"`
+	Username string    `yaml:"username"`
+	Password string    `yaml:"password"`
+	TLS      TLSConfig `yaml:"tls"`
+	// ProtocolVersion is 3.1, 3.1.1 or 5, the client negotiates 3.1.1 or
+	// 3.1 when unset.
+	ProtocolVersion string `yaml:"protocol_version"`
+	// SessionExpiry, UserProperties, AuthMethod and AuthData are only used
+	// with MQTT v5.
+	SessionExpiry  time.Duration     `yaml:"session_expiry"`
+	UserProperties map[string]string `yaml:"user_properties"`
+	AuthMethod     string            `yaml:"auth_method"`
+	AuthData       string            `yaml:"auth_data"`
+	// Collectors are enabled in addition to the ones enabled by default,
+	// DisabledCollectors are disabled even if enabled by default.
+	Collectors         []string          `yaml:"collectors"`
+	DisabledCollectors []string          `yaml:"disabled_collectors"`
+	Labels             map[string]string `yaml:"labels"`
+	Options            *CollectorOptions `yaml:"collector_options"`
+}
+
+// enabledCollectors returns the names of the collectors to create: the
+// listed ones followed by the ones enabled by default, without the disabled
+// ones.
+func (m ModuleConfig) enabledCollectors() []string {
+	var enabled []string
+	for _, collector := range Collectors() {
+		if collector.DefaultEnabled && !slices.Contains(m.Collectors, collector.Name) {
+			enabled = append(enabled, collector.Name)
+		}
+	}
+	enabled = append(slices.Clone(m.Collectors), enabled...)
+	return slices.DeleteFunc(enabled, func(name string) bool {
+		return slices.Contains(m.DisabledCollectors, name)
+	})
+}
+
+// withDefaults returns m where every unset setting is taken from defaults.
+func (m ModuleConfig) withDefaults(defaults ModuleConfig) ModuleConfig {
+	if m.ClientID == "" {
+		m.ClientID = defaults.ClientID
+	}
+	if m.Username == "" {
+		m.Username = defaults.Username
+	}
+	if m.Password == "" {
+		m.Password = defaults.Password
+	}
+	if !m.TLS.Enabled() {
+		m.TLS = defaults.TLS
+	}
+	if m.ProtocolVersion == "" {
+		m.ProtocolVersion = defaults.ProtocolVersion
+	}
+	if m.SessionExpiry == 0 {
+		m.SessionExpiry = defaults.SessionExpiry
+	}
+	if m.UserProperties == nil {
+		m.UserProperties = defaults.UserProperties
+	}
+	if m.AuthMethod == "" {
+		m.AuthMethod, m.AuthData = defaults.AuthMethod, defaults.AuthData
+	}
+	if m.Collectors == nil {
+		m.Collectors = defaults.Collectors
+	}
+	if m.DisabledCollectors == nil {
+		m.DisabledCollectors = defaults.DisabledCollectors
+	}
+	if len(defaults.Labels) > 0 {
+		labels := make(map[string]string, len(defaults.Labels)+len(m.Labels))
+		for name, value := range defaults.Labels {
+			labels[name] = value
+		}
+		for name, value := range m.Labels {
+			labels[name] = value
+		}
+		m.Labels = labels
+	}
+	if m.Options == nil {
+		m.Options = defaults.Options
+	}
+	return m
+}
+
+// BrokerConfig describes how to connect to a broker and which collectors to
+// enable for it.
+type BrokerConfig struct {
+	// Name is used as the broker label, it defaults to URL.
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+	// Module names a module whose settings are used for everything the
+	// broker does not set itself.
+	Module       string `yaml:"module"`
+	ModuleConfig `yaml:",inline"`
+}
+
+// Label returns the value of the broker label for this broker.
+func (c BrokerConfig) Label() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return c.URL
+}
+
+// Broker owns the connection to a single broker along with its collectors.
+type Broker struct {
+	config     BrokerConfig
+	client     connection
+	cancel     context.CancelFunc
+	up         *UpCollector
+	errors     *subscriptionErrors
+	sys        *SysTracker
+	enabled    *collectorStates
+	defaults   *DefaultCollector
+	collectors []Collector
+}
+
+// NewBroker creates the collectors and the MQTT client for cfg. The
+// connection is only established by Connect.
+func NewBroker(cfg BrokerConfig) (*Broker, error) {
+	return newBroker(cfg, true)
+}
+
+// newBroker creates a Broker. Non persistent brokers use a clean session and
+// do not reconnect, they are meant for one-off probes.
+func newBroker(cfg BrokerConfig, persistent bool) (*Broker, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("broker URL is required")
+	}
+	clientID := cfg.ClientID
+	if clientID == "" {
+		clientID = "mosquitto-exporter"
+	}
+	broker, err := newBrokerCollectors(cfg, clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := newClient(cfg, clientID, persistent, func(Client) {
+		log.Printf("Connected to broker %s", cfg.Label())
+		broker.up.SetUp(true)
+		// Subscribing on every connection rather than once after Connect
+		// avoids blocking on a broker that is not reachable yet.
+		if persistent {
+			broker.subscribe()
+		}
+	}, func(err error) {
+		log.Printf("Connection to broker %s lost: %v", cfg.Label(), err)
+		broker.up.SetUp(false)
+	})
+	if err != nil {
+		return nil, err
+	}
+	broker.client = client
+	return broker, nil
+}
+
+// newBrokerCollectors creates a Broker with the collectors enabled by cfg,
+// without its client. The clients opened by the collectors use clientID.
+func newBrokerCollectors(cfg BrokerConfig, clientID string) (*Broker, error) {
+	labels := make(prometheus.Labels, len(cfg.Labels)+1)
+	for name, value := range cfg.Labels {
+		labels[name] = value
+	}
+	labels["broker"] = cfg.Label()
+
+	broker := &Broker{
+		config: cfg,
+		up:     NewUpCollector(labels),
+		errors: newSubscriptionErrors(labels),
+	}
+	options := CollectorOptions{}
+	if cfg.Options != nil {
+		options = *cfg.Options
+	}
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
+	if err := validateCollectorNames(cfg.Collectors, cfg.DisabledCollectors); err != nil {
+		return nil, err
+	}
+	enabled := cfg.enabledCollectors()
+	broker.enabled = newCollectorStates(labels, enabled)
+	for _, name := range enabled {
+		collector := collectorRegistry[name].factory(labels, options)
+		if defaults, ok := collector.(*DefaultCollector); ok {
+			broker.defaults = defaults
+		}
+		broker.collectors = append(broker.collectors, collector)
+	}
+	// The $SYS values mapped in the configuration do not need a collector
+	// to be enabled
+	if len(options.Sys.Mappings) > 0 {
+		broker.collectors = append(broker.collectors, NewSysCollector(labels, SysTable{Mappings: options.Sys.Mappings}))
+	}
+	broker.sys = NewSysTracker(labels, options.Sys)
+	// The uptime and restarts are only known from the default collector,
+	// without it the $SYS interval is unknown and no value is stale.
+	if broker.defaults != nil {
+		broker.defaults.OnUptime(broker.sys.observeUptime)
+	}
+
+	// Some collectors open their own short-lived connections, follow the
+	// broker uptime and restarts or have their $SYS values tracked.
+	for _, collector := range broker.collectors {
+		if user, ok := collector.(interface{ setClientFactory(clientFactory) }); ok {
+			user.setClientFactory(func(suffix string) (connection, error) {
+				return newClient(cfg, clientID+suffix, false, nil, nil)
+			})
+		}
+		if observer, ok := collector.(interface{ observeUptime(uptime float64) }); ok && broker.defaults != nil {
+			broker.defaults.OnUptime(observer.observeUptime)
+		}
+		if observer, ok := collector.(interface{ brokerRestarted() }); ok && broker.defaults != nil {
+			broker.defaults.OnRestart(observer.brokerRestarted)
+		}
+		if tracked, ok := collector.(interface{ setSysTracker(*SysTracker) }); ok {
+			tracked.setSysTracker(broker.sys)
+		}
+	}
+	return broker, nil
+}
+
+// newClient creates the client connecting to the broker described by cfg,
+// using the MQTT v5 client when the protocol version is 5. Persistent
+// clients keep their session and reconnect, others are meant for one-off
+// connections.
+func newClient(cfg BrokerConfig, clientID string, persistent bool, onConnect func(Client), onLost func(error)) (connection, error) {
+	if cfg.ProtocolVersion == "5" {
+		return newV5Client(cfg, clientID, persistent, onConnect, onLost)
+	}
+	return newPahoClient(cfg, clientID, persistent, onConnect, onLost)
+}
+
+// clientFactory creates a client with the connection settings of a broker, the
+// client ID being suffixed with suffix.
+type clientFactory func(suffix string) (connection, error)
+
+// clientOptions returns the connection settings of the MQTT 3 clients
+// connecting to the broker described by cfg.
+func clientOptions(cfg BrokerConfig, clientID string) (*mqtt.ClientOptions, error) {
+	protocolVersion, ok := mqttProtocolVersions[cfg.ProtocolVersion]
+	if !ok {
+		return nil, fmt.Errorf("unsupported protocol version %q", cfg.ProtocolVersion)
+	}
+	mqttOptions := mqtt.NewClientOptions().AddBroker(cfg.URL)
+	mqttOptions.SetProtocolVersion(protocolVersion)
+	mqttOptions.SetClientID(clientID)
+	mqttOptions.SetConnectTimeout(5 * time.Second)
+	mqttOptions.SetUsername(cfg.Username)
+	mqttOptions.SetPassword(cfg.Password)
+	if cfg.TLS.Enabled() {
+		tlsLoader, err := NewTLSLoader(cfg.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TLS configuration: %w", err)
+		}
+		// The TLS configuration is rebuilt before every connection attempt so
+		// rotated certificates are used on reconnect.
+		mqttOptions.SetConnectionAttemptHandler(tlsLoader.ConnectionAttemptHandler)
+	}
+	return mqttOptions, nil
+}
+
+// Connect starts connecting to the broker in the background. The collectors
+// subscribe once the connection is established.
+func (b *Broker) Connect() {
+	ctx, cancel := context.WithCancel(context.Background())
+	b.cancel = cancel
+	go func() {
+		if err := b.client.Connect(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Failed to connect to broker %s: %v", b.config.Label(), err)
+		}
+	}()
+	log.Printf("Attempting to connect to broker %s (async)", b.config.Label())
+	// Connection result will be handled by the connect and connection lost handlers
+}
+
+func (b *Broker) subscribe() {
+	// The default collector subscribes first, so that a broker restart is
+	// detected from the uptime before the other collectors receive the
+	// values of the new broker run.
+	client := b.errors.client(b.client)
+	if b.defaults != nil {
+		b.defaults.Subscribe(client)
+	}
+	for _, collector := range b.collectors {
+		if collector != b.defaults {
+			collector.Subscribe(client)
+		}
+	}
+}
+
+// Disconnect stops the collectors and closes the connection to the broker.
+func (b *Broker) Disconnect() {
+	b.stop()
+	if b.cancel != nil {
+		b.cancel()
+	}
+	b.client.Disconnect(250 * time.Millisecond)
+	b.up.SetUp(false)
+}
+
+// stop ends the background work of the collectors that have some.
+func (b *Broker) stop() {
+	for _, collector := range b.collectors {
+		if stopper, ok := collector.(interface{ Stop() }); ok {
+			stopper.Stop()
+		}
+	}
+}
+
+// Config returns the configuration the broker was created with.
+func (b *Broker) Config() BrokerConfig {
+	return b.config
+}
+
+// Clients returns the clients tracked by the connections collector, if it is
+// enabled.
+func (b *Broker) Clients() []ClientInfo {
+	for _, collector := range b.collectors {
+		if lister, ok := collector.(interface{ ListClients() []ClientInfo }); ok {
+			return lister.ListClients()
+		}
+	}
+	return nil
+}
+
+func (b *Broker) Describe(ch chan<- *prometheus.Desc) {
+	b.up.Describe(ch)
+	b.errors.Describe(ch)
+	b.sys.Describe(ch)
+	b.enabled.Describe(ch)
+	for _, collector := range b.collectors {
+		collector.Describe(ch)
+	}
+}
+
+func (b *Broker) Collect(ch chan<- prometheus.Metric) {
+	b.up.Collect(ch)
+	b.errors.Collect(ch)
+	b.sys.Collect(ch)
+	b.enabled.Collect(ch)
+	for _, collector := range b.collectors {
+		collector.Collect(ch)
+	}
+}
@@ -0,0 +1,204 @@
+package internal
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBrokerConfig_Label(t *testing.T) {
+	assert.Equal(t, "tcp://127.0.0.1:1883", BrokerConfig{URL: "tcp://127.0.0.1:1883"}.Label())
+	assert.Equal(t, "edge-1", BrokerConfig{Name: "edge-1", URL: "tcp://127.0.0.1:1883"}.Label())
+}
+
+func TestNewBroker(t *testing.T) {
+	broker, err := NewBroker(BrokerConfig{
+		URL:          "tcp://127.0.0.1:1883",
+		ModuleConfig: ModuleConfig{Collectors: []string{"clients", "load"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "mosquitto-exporter", broker.client.ClientID())
+	// clients, load and the default collector
+	assert.Len(t, broker.collectors, 3)
+}
+
+func TestNewBroker_Errors(t *testing.T) {
+	_, err := NewBroker(BrokerConfig{})
+	assert.Error(t, err)
+
+	_, err = NewBroker(BrokerConfig{URL: "tcp://127.0.0.1:1883", ModuleConfig: ModuleConfig{Collectors: []string{"unknown"}}})
+	assert.Error(t, err)
+
+	_, err = NewBroker(BrokerConfig{URL: "ssl://127.0.0.1:8883", ModuleConfig: ModuleConfig{TLS: TLSConfig{MinVersion: "SSL3"}}})
+	assert.Error(t, err)
+}
+
+func TestNewBroker_DisabledCollectors(t *testing.T) {
+	broker, err := NewBroker(BrokerConfig{
+		URL: "tcp://127.0.0.1:1883",
+		ModuleConfig: ModuleConfig{
+			Collectors:         []string{"clients", "heap"},
+			DisabledCollectors: []string{"default", "heap"},
+		},
+	})
+	require.NoError(t, err)
+	assert.Nil(t, broker.defaults)
+	assert.Len(t, broker.collectors, 1)
+
+	client := newFakeClient("exporter")
+	broker.client = client
+	broker.subscribe()
+	client.publish("$SYS/broker/uptime", "100 seconds")
+	client.publish("$SYS/broker/clients/connected", "3")
+
+	registry := registryWith(t, broker)
+	assert.Equal(t, []float64{3}, gatherValues(t, registry, "mosquitto_connected_clients_count"))
+	assert.Empty(t, gatherValues(t, registry, "mosquitto_uptime_seconds"))
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	enabled := make(map[string]float64)
+	for _, family := range families {
+		if family.GetName() != "mosquitto_exporter_collector_enabled" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "collector" {
+					enabled[label.GetValue()] = m.GetGauge().GetValue()
+				}
+			}
+		}
+	}
+	assert.Len(t, enabled, len(Collectors()))
+	assert.Equal(t, float64(1), enabled["clients"])
+	assert.Equal(t, float64(0), enabled["heap"])
+	assert.Equal(t, float64(0), enabled["default"])
+
+	_, err = NewBroker(BrokerConfig{URL: "tcp://127.0.0.1:1883", ModuleConfig: ModuleConfig{DisabledCollectors: []string{"unknown"}}})
+	assert.Error(t, err)
+}
+
+func TestCollectors(t *testing.T) {
+	collectors := Collectors()
+	require.NotEmpty(t, collectors)
+	for i, collector := range collectors {
+		if i > 0 {
+			assert.Less(t, collectors[i-1].Name, collector.Name)
+		}
+		assert.Equal(t, collector.Name == "default", collector.DefaultEnabled, collector.Name)
+	}
+	assert.Equal(t, []string{"clients", "load", "default"}, ModuleConfig{Collectors: []string{"clients", "load"}}.enabledCollectors())
+	assert.Equal(t, []string{"default", "clients"}, ModuleConfig{Collectors: []string{"default", "clients"}}.enabledCollectors())
+}
+
+func TestNewBroker_Labels(t *testing.T) {
+	broker, err := NewBroker(BrokerConfig{
+		Name:         "edge-1",
+		URL:          "tcp://127.0.0.1:1883",
+		ModuleConfig: ModuleConfig{Labels: map[string]string{"site": "paris"}},
+	})
+	require.NoError(t, err)
+
+	registry := prometheus.NewRegistry()
+	require.NoError(t, registry.Register(broker))
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		labels := make(map[string]string)
+		for _, label := range family.GetMetric()[0].GetLabel() {
+			labels[label.GetName()] = label.GetValue()
+		}
+		assert.Equal(t, "edge-1", labels["broker"], family.GetName())
+		assert.Equal(t, "paris", labels["site"], family.GetName())
+	}
+}
+
+func TestModuleConfig_WithDefaults(t *testing.T) {
+	defaults := ModuleConfig{
+		Username:   "exporter",
+		Password:   "secret",
+		Collectors: []string{"clients"},
+		Labels:     map[string]string{"site": "paris", "env": "prod"},
+		Options:    &CollectorOptions{Load: LoadOptions{Intervals: []string{"1min"}}},
+	}
+	module := ModuleConfig{
+		Username: "other",
+		Labels:   map[string]string{"env": "staging"},
+	}.withDefaults(defaults)
+
+	assert.Equal(t, "other", module.Username)
+	assert.Equal(t, "secret", module.Password)
+	assert.Equal(t, []string{"clients"}, module.Collectors)
+	assert.Equal(t, map[string]string{"site": "paris", "env": "staging"}, module.Labels)
+	assert.Equal(t, defaults.Options, module.Options)
+}
+
+func TestBroker_Register(t *testing.T) {
+	// Several brokers can be registered side by side thanks to their broker label
+	registry := prometheus.NewRegistry()
+	for _, name := range []string{"edge-1", "edge-2"} {
+		broker, err := NewBroker(BrokerConfig{
+			Name:         name,
+			URL:          "tcp://127.0.0.1:1883",
+			ModuleConfig: ModuleConfig{Collectors: []string{"clients", "messages", "load"}},
+		})
+		require.NoError(t, err)
+		require.NoError(t, registry.Register(broker))
+	}
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() == "mosquitto_up" {
+			assert.Len(t, family.GetMetric(), 2)
+			return
+		}
+	}
+	t.Fatal("mosquitto_up not found")
+}
+
+func TestBroker_SubscriptionErrors(t *testing.T) {
+	// Each broker counts the failed subscriptions of its collectors
+	registry := prometheus.NewRegistry()
+	for _, name := range []string{"edge-1", "edge-2"} {
+		broker, err := NewBroker(BrokerConfig{Name: name, URL: "tcp://127.0.0.1:1883"})
+		require.NoError(t, err)
+		client := newFakeClient("exporter")
+		client.subscribeErrs["$SYS/broker/version"] = errors.New("not authorized")
+		broker.client = client
+		broker.subscribe()
+		broker.subscribe()
+		require.NoError(t, registry.Register(broker))
+	}
+
+	expected := `
+# HELP mosquitto_subscription_errors_total Total number of subscription errors
+# TYPE mosquitto_subscription_errors_total counter
+mosquitto_subscription_errors_total{broker="edge-1",error="not authorized",topic="$SYS/broker/version"} 2
+mosquitto_subscription_errors_total{broker="edge-2",error="not authorized",topic="$SYS/broker/version"} 2
+`
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "mosquitto_subscription_errors_total"))
+}
+
+func TestNewBroker_ClientFactory(t *testing.T) {
+	broker, err := NewBroker(BrokerConfig{
+		URL:          "tcp://127.0.0.1:1883",
+		ModuleConfig: ModuleConfig{ClientID: "exporter", Collectors: []string{"persistence"}},
+	})
+	require.NoError(t, err)
+	collector := broker.collectors[0].(*PersistenceCollector)
+	require.NotNil(t, collector.newClient)
+
+	client, err := collector.newClient("-retained")
+	require.NoError(t, err)
+	require.IsType(t, &pahoClient{}, client)
+	options := client.(*pahoClient).client.OptionsReader()
+	assert.Equal(t, "exporter-retained", options.ClientID())
+	assert.False(t, options.AutoReconnect())
+	assert.True(t, options.CleanSession())
+}
@@ -0,0 +1,170 @@
+package internal
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Message is a message delivered to a subscription.
+type Message interface {
+	Topic() string
+	Payload() []byte
+	Retained() bool
+	Qos() byte
+}
+
+// MessageHandler is called for every message matching a subscription.
+type MessageHandler func(client Client, message Message)
+
+// Client is the part of an MQTT connection the collectors depend on. It is
+// implemented on top of the paho MQTT 3 and MQTT 5 clients, and by an in-memory
+// fake in tests.
+type Client interface {
+	// Subscribe adds handler for the messages matching filter and waits for
+	// the broker to acknowledge the subscription. Several handlers may be
+	// added for the same or overlapping filters, each message is passed once
+	// to every handler whose filter matches.
+	Subscribe(filter string, qos byte, handler MessageHandler) error
+	// Publish sends a message and waits until it is delivered or ctx is done.
+	Publish(ctx context.Context, topic string, qos byte, retained bool, payload []byte) error
+	// IsConnectionOpen reports whether the client is currently connected.
+	IsConnectionOpen() bool
+	ClientID() string
+}
+
+// connection is a Client whose connection is managed by its owner.
+type connection interface {
+	Client
+	// Connect waits until the client is connected or ctx is done. Persistent
+	// clients keep trying, others return the first error.
+	Connect(ctx context.Context) error
+	// Disconnect waits up to quiesce for the pending work to complete and
+	// closes the connection.
+	Disconnect(quiesce time.Duration)
+}
+
+// router dispatches the received messages to the handlers of the matching
+// routes. The paho clients replace the handler when the same filter is
+// subscribed twice, so the adapters keep their own routes.
+//
+// Mosquitto sends a copy of a message for every subscription it matches, so
+// the router only subscribes filters that do not overlap: a filter covered by
+// a subscribed one is not subscribed on its own, and overlapping filters are
+// replaced by a filter covering them. Each message is then received once and
+// passed to every matching route, except for the messages published while
+// the covered filters are being unsubscribed.
+type router struct {
+	mu     sync.RWMutex
+	routes []route
+	// subscribed holds the filters subscribed on the current connection with
+	// their QoS. They never overlap.
+	subscribed map[string]byte
+}
+
+type route struct {
+	filter  string
+	handler MessageHandler
+}
+
+// subscription is the SUBSCRIBE needed by a new route.
+type subscription struct {
+	filter string
+	qos    byte
+	// replaced are the filters subscribed before, with their QoS. The ones
+	// other than filter are covered by it and must be unsubscribed once it is
+	// acknowledged.
+	replaced map[string]byte
+}
+
+// unsubscribe returns the filters replaced by the subscription.
+func (s subscription) unsubscribe() []string {
+	var filters []string
+	for filter := range s.replaced {
+		if filter != s.filter {
+			filters = append(filters, filter)
+		}
+	}
+	sort.Strings(filters)
+	return filters
+}
+
+// add registers handler for filter and returns the subscription to send on
+// the current connection, if any. A filter covered by a subscribed filter
+// subscribes the covering one again, so that the broker sends the retained
+// messages matching filter; the handlers of the covering filter see these
+// retained messages again, as they do on reconnection.
+func (r *router) add(filter string, qos byte, handler MessageHandler) (subscription, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.routes = append(r.routes, route{filter: filter, handler: handler})
+	if granted, ok := r.subscribed[filter]; ok && granted >= qos {
+		return subscription{}, false
+	}
+	if r.subscribed == nil {
+		r.subscribed = make(map[string]byte)
+	}
+	sub := subscription{filter: filter, qos: qos, replaced: make(map[string]byte)}
+	for joined := true; joined; {
+		joined = false
+		for subscribed, granted := range r.subscribed {
+			if _, ok := sub.replaced[subscribed]; ok || !filtersOverlap(sub.filter, subscribed) {
+				continue
+			}
+			sub.filter = joinFilters(sub.filter, subscribed)
+			sub.qos = max(sub.qos, granted)
+			sub.replaced[subscribed] = granted
+			joined = true
+		}
+	}
+	for replaced := range sub.replaced {
+		delete(r.subscribed, replaced)
+	}
+	r.subscribed[sub.filter] = sub.qos
+	return sub, true
+}
+
+// remove forgets the last route added for filter after its subscription
+// failed, the filters it would have replaced are still subscribed.
+func (r *router) remove(filter string, sub subscription) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := len(r.routes) - 1; i >= 0; i-- {
+		if r.routes[i].filter == filter {
+			r.routes = slices.Delete(r.routes, i, i+1)
+			break
+		}
+	}
+	delete(r.subscribed, sub.filter)
+	for replaced, granted := range sub.replaced {
+		r.subscribed[replaced] = granted
+	}
+}
+
+// reset drops every route. The broker subscribes its collectors again on each
+// connection, which adds them back.
+func (r *router) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.routes = nil
+	r.subscribed = nil
+}
+
+// dispatch passes message to the handler of every matching route and returns
+// whether there was one.
+func (r *router) dispatch(client Client, message Message) bool {
+	r.mu.RLock()
+	var handlers []MessageHandler
+	for _, route := range r.routes {
+		if topicMatches(route.filter, message.Topic()) {
+			handlers = append(handlers, route.handler)
+		}
+	}
+	r.mu.RUnlock()
+	for _, handler := range handlers {
+		handler(client, message)
+	}
+	return len(handlers) > 0
+}
@@ -0,0 +1,113 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// subscriptionRejected is the SUBACK return code of a failed subscription.
+const subscriptionRejected = 0x80
+
+var errSubscriptionRejected = errors.New("subscription rejected")
+
+// pahoClient implements Client with the MQTT 3 client of paho.
+type pahoClient struct {
+	client     mqtt.Client
+	clientID   string
+	persistent bool
+	router     router
+}
+
+// newPahoClient creates an MQTT 3 client for cfg. Persistent clients keep
+// their session and reconnect, others give up on the first error.
+func newPahoClient(cfg BrokerConfig, clientID string, persistent bool, onConnect func(Client), onLost func(error)) (*pahoClient, error) {
+	mqttOptions, err := clientOptions(cfg, clientID)
+	if err != nil {
+		return nil, err
+	}
+	client := &pahoClient{clientID: clientID, persistent: persistent}
+	mqttOptions.SetAutoReconnect(persistent)
+	mqttOptions.SetConnectRetry(persistent)
+	mqttOptions.SetResumeSubs(persistent)
+	mqttOptions.SetCleanSession(!persistent)
+	mqttOptions.SetMaxReconnectInterval(30 * time.Second)
+	// Subscriptions are made without a paho route, so every message goes
+	// through the default handler and the router.
+	mqttOptions.SetDefaultPublishHandler(func(_ mqtt.Client, message mqtt.Message) {
+		client.router.dispatch(client, message)
+	})
+	mqttOptions.SetOnConnectHandler(func(mqtt.Client) {
+		if persistent {
+			client.router.reset()
+		}
+		if onConnect != nil {
+			onConnect(client)
+		}
+	})
+	mqttOptions.SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+		if onLost != nil {
+			onLost(err)
+		}
+	})
+	client.client = mqtt.NewClient(mqttOptions)
+	return client, nil
+}
+
+func (c *pahoClient) Connect(ctx context.Context) error {
+	return waitToken(ctx, c.client.Connect())
+}
+
+func (c *pahoClient) Disconnect(quiesce time.Duration) {
+	c.client.Disconnect(uint(quiesce / time.Millisecond))
+}
+
+func (c *pahoClient) Subscribe(filter string, qos byte, handler MessageHandler) error {
+	sub, ok := c.router.add(filter, qos, handler)
+	if !ok {
+		return nil
+	}
+	token := c.client.Subscribe(sub.filter, sub.qos, nil)
+	err := waitToken(context.Background(), token)
+	// paho only reports a subscription refused by the broker in its result
+	if subscribe, ok := token.(*mqtt.SubscribeToken); err == nil && ok && subscribe.Result()[sub.filter] == subscriptionRejected {
+		err = errSubscriptionRejected
+	}
+	if err != nil {
+		c.router.remove(filter, sub)
+		return err
+	}
+	if covered := sub.unsubscribe(); len(covered) > 0 {
+		if err := waitToken(context.Background(), c.client.Unsubscribe(covered...)); err != nil {
+			log.Printf("Failed to unsubscribe from %s: %v", strings.Join(covered, ", "), err)
+		}
+	}
+	return nil
+}
+
+func (c *pahoClient) Publish(ctx context.Context, topic string, qos byte, retained bool, payload []byte) error {
+	return waitToken(ctx, c.client.Publish(topic, qos, retained, payload))
+}
+
+func (c *pahoClient) IsConnectionOpen() bool {
+	return c.client.IsConnectionOpen()
+}
+
+func (c *pahoClient) ClientID() string {
+	return c.clientID
+}
+
+// waitToken waits for token to complete and returns its error, or the error
+// of ctx if it is done first.
+func waitToken(ctx context.Context, token mqtt.Token) error {
+	select {
+	case <-token.Done():
+		return token.Error()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
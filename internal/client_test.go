@@ -0,0 +1,89 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouter(t *testing.T) {
+	var r router
+	received := map[string][]string{}
+	handler := func(name string) MessageHandler {
+		return func(_ Client, message Message) {
+			received[name] = append(received[name], message.Topic())
+		}
+	}
+
+	sub, ok := r.add("$SYS/broker/log/#", 0, handler("log"))
+	assert.True(t, ok)
+	assert.Equal(t, "$SYS/broker/log/#", sub.filter)
+	assert.Empty(t, sub.unsubscribe())
+	// Only subscribed once, both handlers are kept
+	_, ok = r.add("$SYS/broker/log/#", 0, handler("log2"))
+	assert.False(t, ok)
+	// A covered filter subscribes the covering one again for its retained
+	// messages
+	sub, ok = r.add("$SYS/broker/log/N", 0, handler("connections"))
+	assert.True(t, ok)
+	assert.Equal(t, "$SYS/broker/log/#", sub.filter)
+	assert.Empty(t, sub.unsubscribe())
+
+	assert.True(t, r.dispatch(nil, &fakeMessage{topic: "$SYS/broker/log/N"}))
+	assert.True(t, r.dispatch(nil, &fakeMessage{topic: "$SYS/broker/log/E"}))
+	assert.False(t, r.dispatch(nil, &fakeMessage{topic: "devices/d1"}))
+	assert.Equal(t, map[string][]string{
+		"log":         {"$SYS/broker/log/N", "$SYS/broker/log/E"},
+		"log2":        {"$SYS/broker/log/N", "$SYS/broker/log/E"},
+		"connections": {"$SYS/broker/log/N"},
+	}, received)
+
+	// A covering filter replaces the subscribed filters it covers
+	sub, ok = r.add("$SYS/#", 0, handler("discovery"))
+	assert.True(t, ok)
+	assert.Equal(t, "$SYS/#", sub.filter)
+	assert.Equal(t, []string{"$SYS/broker/log/#"}, sub.unsubscribe())
+	assert.Equal(t, map[string]byte{"$SYS/#": 0}, r.subscribed)
+
+	// A failed subscription keeps the previous ones
+	r.remove("$SYS/#", sub)
+	assert.Equal(t, map[string]byte{"$SYS/broker/log/#": 0}, r.subscribed)
+	r.reset()
+	assert.False(t, r.dispatch(nil, &fakeMessage{topic: "$SYS/broker/log/N"}))
+	_, ok = r.add("$SYS/broker/log/N", 0, handler("connections"))
+	assert.True(t, ok)
+}
+
+func TestRouter_OverlappingFilters(t *testing.T) {
+	var r router
+	noop := func(Client, Message) {}
+	r.add("devices/#", 0, noop)
+	r.add("plant/+/heartbeat", 0, noop)
+
+	// Filters overlapping without covering each other are joined
+	sub, ok := r.add("plant/p1/#", 1, noop)
+	assert.True(t, ok)
+	assert.Equal(t, "plant/+/#", sub.filter)
+	assert.Equal(t, byte(1), sub.qos)
+	assert.Equal(t, []string{"plant/+/heartbeat"}, sub.unsubscribe())
+
+	// A higher QoS subscribes the filter again
+	sub, ok = r.add("devices/#", 2, noop)
+	assert.True(t, ok)
+	assert.Equal(t, "devices/#", sub.filter)
+	assert.Empty(t, sub.unsubscribe())
+	assert.Equal(t, map[string]byte{"devices/#": 2, "plant/+/#": 1}, r.subscribed)
+}
+
+func TestFakeClient_OverlappingSubscriptions(t *testing.T) {
+	client := newFakeClient("exporter")
+	var log, discovery int
+	require.NoError(t, client.Subscribe("$SYS/broker/log/#", 0, func(Client, Message) { log++ }))
+	require.NoError(t, client.Subscribe("$SYS/#", 0, func(Client, Message) { discovery++ }))
+
+	// The broker sends one copy per subscription, each handler gets one
+	client.publish("$SYS/broker/log/N", "line")
+	assert.Equal(t, 1, log)
+	assert.Equal(t, 1, discovery)
+}
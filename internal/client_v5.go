@@ -0,0 +1,293 @@
+package internal
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/packets"
+	"github.com/eclipse/paho.golang/paho"
+)
+
+var errV5NotConnected = errors.New("not connected")
+
+// v5DefaultPorts are the standard MQTT ports, used for the broker URLs
+// without one. Websocket URLs default to the HTTP ports.
+var v5DefaultPorts = map[string]string{
+	"tcp":   "1883",
+	"mqtt":  "1883",
+	"ssl":   "8883",
+	"tls":   "8883",
+	"mqtts": "8883",
+	"tcps":  "8883",
+}
+
+// tlsSchemes are the URL schemes of the connections using TLS.
+var tlsSchemes = map[string]bool{
+	"ssl":   true,
+	"tls":   true,
+	"mqtts": true,
+	"tcps":  true,
+	"wss":   true,
+}
+
+// v5Client implements Client on top of the MQTT v5 client of paho.golang,
+// so the collectors work the same with every protocol version.
+type v5Client struct {
+	config     autopaho.ClientConfig
+	clientID   string
+	persistent bool
+	onConnect  func(Client)
+	onLost     func(error)
+	router     router
+
+	mu        sync.RWMutex
+	manager   *autopaho.ConnectionManager
+	ctx       context.Context
+	cancel    context.CancelFunc
+	connected atomic.Bool
+}
+
+// newV5Client creates an MQTT v5 client for cfg. Like the MQTT 3 client,
+// persistent clients reconnect and keep their session, others give up on the
+// first error.
+func newV5Client(cfg BrokerConfig, clientID string, persistent bool, onConnect func(Client), onLost func(error)) (*v5Client, error) {
+	serverURL, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid broker URL: %w", err)
+	}
+	// The connection manager dials the host of the URL as is
+	scheme := strings.ToLower(serverURL.Scheme)
+	if port, ok := v5DefaultPorts[scheme]; ok && serverURL.Port() == "" {
+		serverURL.Host = net.JoinHostPort(serverURL.Hostname(), port)
+	}
+	client := &v5Client{
+		clientID:   clientID,
+		persistent: persistent,
+		onConnect:  onConnect,
+		onLost:     onLost,
+	}
+	client.config = autopaho.ClientConfig{
+		ServerUrls:                    []*url.URL{serverURL},
+		KeepAlive:                     30,
+		CleanStartOnInitialConnection: !persistent,
+		ReconnectBackoff:              autopaho.NewExponentialBackoff(time.Second, 30*time.Second, 2*time.Second, 2),
+		ConnectTimeout:                5 * time.Second,
+		ConnectUsername:               cfg.Username,
+		ConnectPassword:               []byte(cfg.Password),
+		ClientConfig: paho.ClientConfig{
+			ClientID:          clientID,
+			OnPublishReceived: []func(paho.PublishReceived) (bool, error){client.publishReceived},
+		},
+	}
+	if persistent {
+		client.config.SessionExpiryInterval = uint32(cfg.SessionExpiry / time.Second)
+	}
+	if cfg.AuthMethod != "" {
+		client.config.AuthHandler = &v5StaticAuth{method: cfg.AuthMethod, data: []byte(cfg.AuthData)}
+	}
+	client.config.ConnectPacketBuilder = func(connect *paho.Connect, _ *url.URL) (*paho.Connect, error) {
+		if connect.Properties == nil {
+			connect.Properties = &paho.ConnectProperties{}
+		}
+		connect.Properties.AuthMethod = cfg.AuthMethod
+		connect.Properties.AuthData = []byte(cfg.AuthData)
+		for name, value := range cfg.UserProperties {
+			connect.Properties.User.Add(name, value)
+		}
+		return connect, nil
+	}
+	if cfg.TLS.Enabled() && tlsSchemes[scheme] {
+		tlsLoader, err := NewTLSLoader(cfg.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TLS configuration: %w", err)
+		}
+		switch scheme {
+		case "ssl", "tls", "mqtts", "tcps":
+			// The TLS configuration is rebuilt before every connection attempt
+			// so rotated certificates are used on reconnect.
+			client.config.AttemptConnection = func(ctx context.Context, _ autopaho.ClientConfig, u *url.URL) (net.Conn, error) {
+				tlsConfig, err := tlsLoader.Config()
+				if err != nil {
+					return nil, err
+				}
+				dialer := &tls.Dialer{Config: tlsConfig}
+				conn, err := dialer.DialContext(ctx, "tcp", u.Host)
+				if err != nil {
+					return nil, err
+				}
+				return packets.NewThreadSafeConn(conn), nil
+			}
+		case "wss":
+			if client.config.TlsCfg, err = tlsLoader.Config(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return client, nil
+}
+
+func (c *v5Client) IsConnectionOpen() bool {
+	return c.connected.Load()
+}
+
+func (c *v5Client) ClientID() string {
+	return c.clientID
+}
+
+// Connect starts the connection manager and waits until it is connected, or
+// until the first error for non persistent clients.
+func (c *v5Client) Connect(ctx context.Context) error {
+	result := make(chan error, 1)
+	var once sync.Once
+	complete := func(err error) {
+		once.Do(func() { result <- err })
+	}
+	managerCtx, cancel := context.WithCancel(context.Background())
+	config := c.config
+	config.OnConnectionUp = func(manager *autopaho.ConnectionManager, _ *paho.Connack) {
+		// The first connection may be up before NewConnection returns, the
+		// manager must be known to the subscriptions of onConnect.
+		c.setConnection(manager, managerCtx, cancel)
+		c.connected.Store(true)
+		complete(nil)
+		go func() {
+			if c.persistent {
+				c.router.reset()
+			}
+			if c.onConnect != nil {
+				c.onConnect(c)
+			}
+		}()
+	}
+	config.OnConnectionDown = func() bool {
+		c.connected.Store(false)
+		if c.onLost != nil {
+			go c.onLost(errors.New("connection lost"))
+		}
+		return c.persistent
+	}
+	config.OnConnectError = func(err error) {
+		if !c.persistent {
+			complete(err)
+			cancel()
+		}
+	}
+	manager, err := autopaho.NewConnection(managerCtx, config)
+	if err != nil {
+		cancel()
+		return err
+	}
+	c.setConnection(manager, managerCtx, cancel)
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Disconnect waits up to quiesce for the connection to close.
+func (c *v5Client) Disconnect(quiesce time.Duration) {
+	c.mu.Lock()
+	manager, cancel := c.manager, c.cancel
+	c.manager = nil
+	c.mu.Unlock()
+	if manager == nil {
+		return
+	}
+	ctx, done := context.WithTimeout(context.Background(), quiesce)
+	defer done()
+	manager.Disconnect(ctx)
+	cancel()
+	c.connected.Store(false)
+}
+
+func (c *v5Client) Publish(ctx context.Context, topic string, qos byte, retained bool, payload []byte) error {
+	manager, _ := c.connection()
+	if manager == nil || !c.connected.Load() {
+		// The connection manager would wait for the connection
+		return errV5NotConnected
+	}
+	_, err := manager.Publish(ctx, &paho.Publish{Topic: topic, QoS: qos, Retain: retained, Payload: payload})
+	return err
+}
+
+func (c *v5Client) Subscribe(filter string, qos byte, handler MessageHandler) error {
+	sub, ok := c.router.add(filter, qos, handler)
+	if !ok {
+		return nil
+	}
+	manager, ctx := c.connection()
+	err := errV5NotConnected
+	if manager != nil {
+		_, err = manager.Subscribe(ctx, &paho.Subscribe{Subscriptions: []paho.SubscribeOptions{{Topic: sub.filter, QoS: sub.qos}}})
+	}
+	if err != nil {
+		c.router.remove(filter, sub)
+		return err
+	}
+	if covered := sub.unsubscribe(); len(covered) > 0 {
+		if _, err := manager.Unsubscribe(ctx, &paho.Unsubscribe{Topics: covered}); err != nil {
+			log.Printf("Failed to unsubscribe from %s: %v", strings.Join(covered, ", "), err)
+		}
+	}
+	return nil
+}
+
+// setConnection stores the connection manager, unless it was disconnected
+// meanwhile.
+func (c *v5Client) setConnection(manager *autopaho.ConnectionManager, ctx context.Context, cancel context.CancelFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ctx.Err() == nil {
+		c.manager, c.ctx, c.cancel = manager, ctx, cancel
+	}
+}
+
+func (c *v5Client) connection() (*autopaho.ConnectionManager, context.Context) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.manager, c.ctx
+}
+
+// publishReceived dispatches a message to the handlers of every matching
+// route.
+func (c *v5Client) publishReceived(received paho.PublishReceived) (bool, error) {
+	return c.router.dispatch(c, &v5Message{publish: received.Packet}), nil
+}
+
+// v5Message implements Message.
+type v5Message struct {
+	publish *paho.Publish
+}
+
+func (m *v5Message) Topic() string   { return m.publish.Topic }
+func (m *v5Message) Payload() []byte { return m.publish.Payload }
+func (m *v5Message) Retained() bool  { return m.publish.Retain }
+func (m *v5Message) Qos() byte       { return m.publish.QoS }
+
+// v5StaticAuth answers the AUTH challenges of the broker with the configured
+// authentication data.
+type v5StaticAuth struct {
+	method string
+	data   []byte
+}
+
+func (a *v5StaticAuth) Authenticate(*paho.Auth) *paho.Auth {
+	return &paho.Auth{
+		ReasonCode: packets.AuthContinueAuthentication,
+		Properties: &paho.AuthProperties{AuthMethod: a.method, AuthData: a.data},
+	}
+}
+
+func (a *v5StaticAuth) Authenticated() {}
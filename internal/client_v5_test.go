@@ -0,0 +1,180 @@
+package internal
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.golang/packets"
+	"github.com/eclipse/paho.golang/paho"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewClient_ProtocolVersion(t *testing.T) {
+	client, err := newClient(BrokerConfig{URL: "tcp://127.0.0.1:1883", ModuleConfig: ModuleConfig{ProtocolVersion: "3.1.1"}}, "exporter", true, nil, nil)
+	require.NoError(t, err)
+	require.IsType(t, &pahoClient{}, client)
+	options := client.(*pahoClient).client.OptionsReader()
+	assert.Equal(t, uint(4), options.ProtocolVersion())
+
+	client, err = newClient(BrokerConfig{URL: "tcp://127.0.0.1:1883", ModuleConfig: ModuleConfig{ProtocolVersion: "5"}}, "exporter", true, nil, nil)
+	require.NoError(t, err)
+	require.IsType(t, &v5Client{}, client)
+	assert.Equal(t, "exporter", client.ClientID())
+
+	_, err = newClient(BrokerConfig{URL: "tcp://127.0.0.1:1883", ModuleConfig: ModuleConfig{ProtocolVersion: "4"}}, "exporter", true, nil, nil)
+	assert.Error(t, err)
+}
+
+func TestV5Client_PublishReceived(t *testing.T) {
+	client, err := newV5Client(BrokerConfig{URL: "tcp://127.0.0.1:1883"}, "exporter", true, nil, nil)
+	require.NoError(t, err)
+
+	var received []Message
+	client.router.add("$SYS/broker/log/#", 0, func(_ Client, message Message) {
+		received = append(received, message)
+	})
+
+	handled, err := client.publishReceived(paho.PublishReceived{Packet: &paho.Publish{Topic: "$SYS/broker/log/N", Payload: []byte("line"), QoS: 1, Retain: true}})
+	require.NoError(t, err)
+	assert.True(t, handled)
+	handled, _ = client.publishReceived(paho.PublishReceived{Packet: &paho.Publish{Topic: "devices/d1"}})
+	assert.False(t, handled)
+
+	require.Len(t, received, 1)
+	assert.Equal(t, "$SYS/broker/log/N", received[0].Topic())
+	assert.Equal(t, "line", string(received[0].Payload()))
+	assert.Equal(t, byte(1), received[0].Qos())
+	assert.True(t, received[0].Retained())
+}
+
+func TestNewV5Client_URL(t *testing.T) {
+	for url, host := range map[string]string{
+		"tcp://broker":      "broker:1883",
+		"mqtt://broker":     "broker:1883",
+		"mqtts://broker":    "broker:8883",
+		"ssl://broker:8884": "broker:8884",
+		"ws://broker/mqtt":  "broker",
+		"tcp://[::1]":       "[::1]:1883",
+	} {
+		client, err := newV5Client(BrokerConfig{URL: url}, "exporter", true, nil, nil)
+		require.NoError(t, err)
+		assert.Equal(t, host, client.config.ServerUrls[0].Host, url)
+	}
+
+	// TLS is only set up for the schemes using it
+	tlsConfig := TLSConfig{InsecureSkipVerify: true}
+	client, err := newV5Client(BrokerConfig{URL: "tcp://broker", ModuleConfig: ModuleConfig{TLS: tlsConfig}}, "exporter", true, nil, nil)
+	require.NoError(t, err)
+	assert.Nil(t, client.config.TlsCfg)
+	assert.Nil(t, client.config.AttemptConnection)
+	client, err = newV5Client(BrokerConfig{URL: "wss://broker/mqtt", ModuleConfig: ModuleConfig{TLS: tlsConfig}}, "exporter", true, nil, nil)
+	require.NoError(t, err)
+	assert.NotNil(t, client.config.TlsCfg)
+	client, err = newV5Client(BrokerConfig{URL: "mqtts://broker", ModuleConfig: ModuleConfig{TLS: tlsConfig}}, "exporter", true, nil, nil)
+	require.NoError(t, err)
+	assert.NotNil(t, client.config.AttemptConnection)
+}
+
+func TestV5Client_NotConnected(t *testing.T) {
+	client, err := newV5Client(BrokerConfig{URL: "tcp://127.0.0.1:1883"}, "exporter", true, nil, nil)
+	require.NoError(t, err)
+
+	assert.False(t, client.IsConnectionOpen())
+	assert.Error(t, client.Subscribe("$SYS/broker/uptime", 0, func(Client, Message) {}))
+	// The failed subscription is not kept
+	_, ok := client.router.add("$SYS/broker/uptime", 0, func(Client, Message) {})
+	assert.True(t, ok)
+	assert.Error(t, client.Publish(context.Background(), "probe", 0, false, []byte("42")))
+	// Disconnecting a client that never connected is a no-op
+	client.Disconnect(0)
+}
+
+func TestV5Client_ConnectFailure(t *testing.T) {
+	client, err := newV5Client(BrokerConfig{URL: "tcp://" + closedAddress(t)}, "exporter", false, nil, nil)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = client.Connect(ctx)
+	assert.Error(t, err)
+	assert.NoError(t, ctx.Err())
+	client.Disconnect(0)
+}
+
+func TestModuleConfig_ValidateProtocolVersion(t *testing.T) {
+	assert.NoError(t, ModuleConfig{ProtocolVersion: "5", SessionExpiry: time.Hour, AuthMethod: "token", AuthData: "secret"}.validate())
+	assert.NoError(t, ModuleConfig{ProtocolVersion: "3.1"}.validate())
+	assert.Error(t, ModuleConfig{ProtocolVersion: "4"}.validate())
+	assert.Error(t, ModuleConfig{SessionExpiry: time.Hour}.validate())
+	assert.Error(t, ModuleConfig{ProtocolVersion: "5", AuthData: "secret"}.validate())
+	assert.Error(t, ModuleConfig{ProtocolVersion: "5", SessionExpiry: -time.Second}.validate())
+}
+
+// serveV5 accepts one MQTT 5 connection, acknowledges it and its
+// subscriptions, and sends the subscribed filters on subscribed.
+func serveV5(t *testing.T, subscribed chan<- string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			packet, err := packets.ReadPacket(conn)
+			if err != nil {
+				return
+			}
+			var reply *packets.ControlPacket
+			switch content := packet.Content.(type) {
+			case *packets.Connect:
+				reply = packets.NewControlPacket(packets.CONNACK)
+			case *packets.Subscribe:
+				reply = packets.NewControlPacket(packets.SUBACK)
+				suback := reply.Content.(*packets.Suback)
+				suback.PacketID = content.PacketID
+				for _, subscription := range content.Subscriptions {
+					suback.Reasons = append(suback.Reasons, subscription.QoS)
+					subscribed <- subscription.Topic
+				}
+			case *packets.Disconnect:
+				return
+			default:
+				continue
+			}
+			if _, err := reply.WriteTo(conn); err != nil {
+				return
+			}
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestV5Client_SubscribeOnConnect(t *testing.T) {
+	subscribed := make(chan string, 1)
+	errs := make(chan error, 1)
+	onConnect := func(client Client) {
+		errs <- client.Subscribe("$SYS/#", 0, func(Client, Message) {})
+	}
+	client, err := newV5Client(BrokerConfig{URL: "tcp://" + serveV5(t, subscribed)}, "exporter", true, onConnect, nil)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, client.Connect(ctx))
+	defer client.Disconnect(time.Second)
+
+	// onConnect subscribes with the manager of the connection
+	select {
+	case err := <-errs:
+		require.NoError(t, err)
+	case <-ctx.Done():
+		t.Fatal("onConnect was not called")
+	}
+	assert.Equal(t, "$SYS/#", <-subscribed)
+}
@@ -0,0 +1,32 @@
+package internal
+
+import (
+	"slices"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// collectorStates exports which of the registered collectors are enabled for
+// a broker.
+type collectorStates struct {
+	description *prometheus.Desc
+	enabled     []string
+}
+
+func newCollectorStates(labels prometheus.Labels, enabled []string) *collectorStates {
+	return &collectorStates{
+		description: prometheus.NewDesc("mosquitto_exporter_collector_enabled", "Whether a collector is enabled for the broker", []string{"collector"}, labels),
+		enabled:     enabled,
+	}
+}
+
+func (c *collectorStates) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.description
+}
+
+func (c *collectorStates) Collect(ch chan<- prometheus.Metric) {
+	for _, collector := range Collectors() {
+		enabled := slices.Contains(c.enabled, collector.Name)
+		ch <- prometheus.MustNewConstMetric(c.description, prometheus.GaugeValue, boolToFloat(enabled), collector.Name)
+	}
+}
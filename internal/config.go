@@ -0,0 +1,151 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v3"
+)
+
+var supportedSchemes = map[string]bool{
+	"tcp":   true,
+	"mqtt":  true,
+	"ssl":   true,
+	"tls":   true,
+	"mqtts": true,
+	"tcps":  true,
+	"ws":    true,
+	"wss":   true,
+}
+
+// Config is the content of the configuration file.
+type Config struct {
+	Brokers []BrokerConfig          `yaml:"brokers"`
+	Modules map[string]ModuleConfig `yaml:"modules"`
+}
+
+// LoadConfig reads and validates the configuration file at path. Module
+// references of the brokers are resolved and a "default" module is always
+// present in the returned configuration.
+func LoadConfig(path string) (*Config, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	// Brokers are validated with the defaults of their module, an unknown
+	// module is reported by validate.
+	for i, broker := range config.Brokers {
+		if broker.Module != "" {
+			config.Brokers[i].ModuleConfig = broker.ModuleConfig.withDefaults(config.Modules[broker.Module])
+		}
+	}
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration %s: %w", path, err)
+	}
+	if config.Modules == nil {
+		config.Modules = make(map[string]ModuleConfig, 1)
+	}
+	if _, ok := config.Modules["default"]; !ok {
+		config.Modules["default"] = ModuleConfig{}
+	}
+	return config, nil
+}
+
+func (c *Config) validate() error {
+	if len(c.Brokers) == 0 && len(c.Modules) == 0 {
+		return fmt.Errorf("no broker or module configured")
+	}
+	for name, module := range c.Modules {
+		if err := module.validate(); err != nil {
+			return fmt.Errorf("modules[%s]: %w", name, err)
+		}
+	}
+	labels := make(map[string]bool, len(c.Brokers))
+	for i, broker := range c.Brokers {
+		if broker.URL == "" {
+			return fmt.Errorf("brokers[%d]: url is required", i)
+		}
+		u, err := url.Parse(broker.URL)
+		if err != nil {
+			return fmt.Errorf("brokers[%d]: invalid url: %w", i, err)
+		}
+		if !supportedSchemes[u.Scheme] {
+			return fmt.Errorf("brokers[%d]: unsupported url scheme %q", i, u.Scheme)
+		}
+		if labels[broker.Label()] {
+			return fmt.Errorf("brokers[%d]: duplicate broker %q", i, broker.Label())
+		}
+		labels[broker.Label()] = true
+		if broker.Module != "" {
+			if _, ok := c.Modules[broker.Module]; !ok {
+				return fmt.Errorf("brokers[%d]: unknown module %q", i, broker.Module)
+			}
+		}
+		if err := broker.ModuleConfig.validate(); err != nil {
+			return fmt.Errorf("brokers[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (m ModuleConfig) validate() error {
+	if err := validateCollectorNames(m.Collectors, m.DisabledCollectors); err != nil {
+		return err
+	}
+	for name := range m.Labels {
+		if !model.LegacyValidation.IsValidLabelName(name) {
+			return fmt.Errorf("invalid label name %q", name)
+		}
+		if name == "broker" {
+			return fmt.Errorf("label %q is reserved", name)
+		}
+	}
+	if (m.TLS.CertFile == "") != (m.TLS.KeyFile == "") {
+		return fmt.Errorf("tls: both cert_file and key_file must be set")
+	}
+	if _, err := parseTLSVersion(m.TLS.MinVersion); err != nil {
+		return fmt.Errorf("tls: %w", err)
+	}
+	if _, ok := mqttProtocolVersions[m.ProtocolVersion]; !ok && m.ProtocolVersion != "5" {
+		return fmt.Errorf("unsupported protocol_version %q", m.ProtocolVersion)
+	}
+	if m.ProtocolVersion != "5" && (m.SessionExpiry != 0 || len(m.UserProperties) > 0 || m.AuthMethod != "" || m.AuthData != "") {
+		return fmt.Errorf("session_expiry, user_properties, auth_method and auth_data require protocol_version 5")
+	}
+	if m.SessionExpiry < 0 {
+		return errNegativeDuration("session_expiry")
+	}
+	if m.AuthData != "" && m.AuthMethod == "" {
+		return fmt.Errorf("auth_data requires auth_method")
+	}
+	if m.Options != nil {
+		if err := m.Options.validate(); err != nil {
+			return fmt.Errorf("collector_options: %w", err)
+		}
+	}
+	return m.validateMetrics()
+}
+
+// validateMetrics registers the metrics of the enabled collectors on a
+// scratch registry, so that the metrics and labels defined in the collector
+// options are checked against the built-in ones and the labels of the broker.
+func (m ModuleConfig) validateMetrics() error {
+	broker, err := newBrokerCollectors(BrokerConfig{ModuleConfig: m}, m.ClientID)
+	if err != nil {
+		return err
+	}
+	if err := prometheus.NewPedanticRegistry().Register(broker); err != nil {
+		return fmt.Errorf("collector_options: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := writeConfig(t, `
+brokers:
+  - name: edge-1
+    url: tcp://edge-1:1883
+    username: exporter
+    password: secret
+    collectors: [clients, load]
+  - url: ssl://edge-2:8883
+    client_id: exporter-2
+    tls:
+      ca_file: /etc/ssl/ca.pem
+      insecure_skip_verify: true
+`)
+	config, err := LoadConfig(path)
+	require.NoError(t, err)
+	require.Len(t, config.Brokers, 2)
+	assert.Equal(t, "edge-1", config.Brokers[0].Label())
+	assert.Equal(t, []string{"clients", "load"}, config.Brokers[0].Collectors)
+	assert.Equal(t, "ssl://edge-2:8883", config.Brokers[1].Label())
+	assert.Equal(t, "/etc/ssl/ca.pem", config.Brokers[1].TLS.CAFile)
+	assert.True(t, config.Brokers[1].TLS.InsecureSkipVerify)
+}
+
+func TestLoadConfig_Modules(t *testing.T) {
+	path := writeConfig(t, `
+modules:
+  default:
+    collectors: [clients]
+  secure:
+    username: prober
+    tls:
+      ca_file: /etc/ssl/ca.pem
+`)
+	config, err := LoadConfig(path)
+	require.NoError(t, err)
+	assert.Empty(t, config.Brokers)
+	require.Len(t, config.Modules, 2)
+	assert.Equal(t, []string{"clients"}, config.Modules["default"].Collectors)
+	assert.Equal(t, "prober", config.Modules["secure"].Username)
+	assert.True(t, config.Modules["secure"].TLS.Enabled())
+}
+
+func TestLoadConfig_Errors(t *testing.T) {
+	testCases := map[string]string{
+		"empty":             `brokers: []`,
+		"module collector":  "modules:\n  default:\n    collectors: [foo]\n",
+		"missing url":       "brokers:\n  - name: edge-1\n",
+		"duplicate":         "brokers:\n  - url: tcp://a:1883\n  - url: tcp://a:1883\n",
+		"unknown collector": "brokers:\n  - url: tcp://a:1883\n    collectors: [foo]\n",
+		"unknown disabled":  "brokers:\n  - url: tcp://a:1883\n    disabled_collectors: [foo]\n",
+		"unknown field":     "brokers:\n  - url: tcp://a:1883\n    port: 1883\n",
+		"bad scheme":        "brokers:\n  - url: http://a:1883\n",
+		"unknown module":    "brokers:\n  - url: tcp://a:1883\n    module: foo\n",
+		"bad label":         "brokers:\n  - url: tcp://a:1883\n    labels:\n      bad-label: x\n",
+		"reserved label":    "brokers:\n  - url: tcp://a:1883\n    labels:\n      broker: x\n",
+		"missing key":       "brokers:\n  - url: ssl://a:8883\n    tls:\n      cert_file: cert.pem\n",
+		"bad tls version":   "brokers:\n  - url: ssl://a:8883\n    tls:\n      min_version: SSL3\n",
+		"bad load interval": "brokers:\n  - url: tcp://a:1883\n    collector_options:\n      load:\n        intervals: [1h]\n",
+	}
+	for name, content := range testCases {
+		_, err := LoadConfig(writeConfig(t, content))
+		assert.Error(t, err, name)
+	}
+
+	_, err := LoadConfig(filepath.Join(t.TempDir(), "missing.yml"))
+	assert.Error(t, err)
+}
+
+func TestLoadConfig_BrokerModule(t *testing.T) {
+	path := writeConfig(t, `
+modules:
+  edge:
+    username: exporter
+    password: secret
+    collectors: [clients, load]
+    labels:
+      env: prod
+    collector_options:
+      load:
+        intervals: [1min]
+      bridge:
+        stale_after: 1h
+brokers:
+  - name: edge-1
+    url: tcp://edge-1:1883
+    module: edge
+    labels:
+      site: paris
+  - name: edge-2
+    url: tcp://edge-2:1883
+    module: edge
+    username: other
+    collectors: [messages]
+`)
+	config, err := LoadConfig(path)
+	require.NoError(t, err)
+	require.Len(t, config.Brokers, 2)
+
+	edge1 := config.Brokers[0]
+	assert.Equal(t, "exporter", edge1.Username)
+	assert.Equal(t, []string{"clients", "load"}, edge1.Collectors)
+	assert.Equal(t, map[string]string{"env": "prod", "site": "paris"}, edge1.Labels)
+	assert.Equal(t, []string{"1min"}, edge1.Options.Load.Intervals)
+	assert.Equal(t, time.Hour, edge1.Options.Bridge.StaleAfter)
+
+	edge2 := config.Brokers[1]
+	assert.Equal(t, "other", edge2.Username)
+	assert.Equal(t, "secret", edge2.Password)
+	assert.Equal(t, []string{"messages"}, edge2.Collectors)
+
+	// The default module is always available to probes
+	assert.Contains(t, config.Modules, "default")
+}
+
+func TestLoadConfig_BrokerModuleV5(t *testing.T) {
+	path := writeConfig(t, `
+modules:
+  v5:
+    protocol_version: "5"
+brokers:
+  - url: tcp://edge-1:1883
+    module: v5
+    session_expiry: 1h
+    user_properties:
+      site: paris
+`)
+	config, err := LoadConfig(path)
+	require.NoError(t, err)
+	require.Len(t, config.Brokers, 1)
+	assert.Equal(t, "5", config.Brokers[0].ProtocolVersion)
+	assert.Equal(t, time.Hour, config.Brokers[0].SessionExpiry)
+}
@@ -0,0 +1,213 @@
+package internal
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const connectionsTopic = "$SYS/broker/log/N"
+
+var (
+	// '1700000000: New client connected from 10.0.0.1:51234 as sensor-1 (p2, c1, k60, u'device').'
+	clientConnectedRegexp = regexp.MustCompile(`New client connected from (\S+) as (\S+) \(p(\d+), c\d+, k(\d+)(?:, u'([^']*)')?\)`)
+	// All the ways a client can leave the broker
+	clientDisconnectedRegexps = []*regexp.Regexp{
+		regexp.MustCompile(`Client (\S+) disconnected`),
+		regexp.MustCompile(`Client (\S+) closed its connection`),
+		regexp.MustCompile(`Client (\S+) has exceeded timeout`),
+		regexp.MustCompile(`Socket error on client (\S+),`),
+	}
+	protocolVersions = map[string]string{
+		"1": "3.1",
+		"2": "3.1.1",
+		"5": "5.0",
+	}
+)
+
+// ConnectionsOptions configures the connections collector.
+type ConnectionsOptions struct {
+	// InfoMetrics enables the per-client series.
+	InfoMetrics bool `yaml:"info_metrics"`
+	// MaxSeries caps the number of clients exported as series.
+	MaxSeries int `yaml:"max_series"`
+	// RegexpFilter selects the clients exported as series by their ID.
+	RegexpFilter `yaml:",inline"`
+}
+
+func (o ConnectionsOptions) validate() error {
+	if o.MaxSeries < 0 {
+		return fmt.Errorf("connections.max_series must not be negative")
+	}
+	if err := o.RegexpFilter.validate(); err != nil {
+		return fmt.Errorf("connections: %w", err)
+	}
+	return nil
+}
+
+// ClientInfo describes a client connected to a broker.
+type ClientInfo struct {
+	Broker          string    `json:"broker"`
+	ClientID        string    `json:"client_id"`
+	Address         string    `json:"address"`
+	ProtocolVersion string    `json:"protocol_version"`
+	KeepAlive       int       `json:"keepalive"`
+	Username        string    `json:"username,omitempty"`
+	ConnectedAt     time.Time `json:"connected_at"`
+}
+
+// ConnectionsCollector keeps a table of the clients connected to the broker,
+// built from the connection and disconnection lines of the broker log.
+type ConnectionsCollector struct {
+	mu           sync.RWMutex
+	Clients      map[string]*ClientInfo
+	descriptions map[string]metric
+	broker       string
+	options      ConnectionsOptions
+	exported     regexpMatcher
+	now          func() time.Time
+}
+
+func init() {
+	registerCollector("connections", false, "Enable the connections collector, tracking connected clients from the broker log. Requires 'log_dest topic' on the broker.", func(labels prometheus.Labels, options CollectorOptions) Collector {
+		return NewConnectionsCollector(labels, options.Connections)
+	})
+}
+
+func NewConnectionsCollector(labels prometheus.Labels, options ConnectionsOptions) *ConnectionsCollector {
+	collector := &ConnectionsCollector{
+		mu:      sync.RWMutex{},
+		Clients: make(map[string]*ClientInfo, 64),
+		descriptions: map[string]metric{
+			"tracked": {
+				desc:      prometheus.NewDesc("mosquitto_tracked_clients", "Number of connected clients tracked from the broker log", nil, labels),
+				valueType: prometheus.GaugeValue,
+			},
+			"info": {
+				desc:      prometheus.NewDesc("mosquitto_client_info", "Information about a connected client", []string{"client_id", "address", "protocol_version", "keepalive", "username"}, labels),
+				valueType: prometheus.GaugeValue,
+			},
+			"connected": {
+				desc:      prometheus.NewDesc("mosquitto_client_connected_timestamp_seconds", "Time the client connected to the broker", []string{"client_id"}, labels),
+				valueType: prometheus.GaugeValue,
+			},
+		},
+		broker:   labels["broker"],
+		options:  options,
+		exported: options.RegexpFilter.compile(),
+		now:      time.Now,
+	}
+	return collector
+}
+
+func (collector *ConnectionsCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range collector.descriptions {
+		ch <- desc.desc
+	}
+}
+
+func (collector *ConnectionsCollector) Collect(ch chan<- prometheus.Metric) {
+	collector.mu.RLock()
+	defer collector.mu.RUnlock()
+
+	tracked := collector.descriptions["tracked"]
+	ch <- prometheus.MustNewConstMetric(tracked.desc, tracked.valueType, float64(len(collector.Clients)))
+	if !collector.options.InfoMetrics {
+		return
+	}
+
+	info := collector.descriptions["info"]
+	connected := collector.descriptions["connected"]
+	series := 0
+	for _, client := range collector.sortedClients() {
+		if !collector.exported.matches(client.ClientID) {
+			continue
+		}
+		if collector.options.MaxSeries > 0 && series >= collector.options.MaxSeries {
+			break
+		}
+		series++
+		ch <- prometheus.MustNewConstMetric(info.desc, info.valueType, 1,
+			client.ClientID, client.Address, client.ProtocolVersion, strconv.Itoa(client.KeepAlive), client.Username)
+		ch <- prometheus.MustNewConstMetric(connected.desc, connected.valueType, float64(client.ConnectedAt.Unix()), client.ClientID)
+	}
+}
+
+func (collector *ConnectionsCollector) Subscribe(client Client) {
+	if err := client.Subscribe(connectionsTopic, 0, collector.logHandler); err != nil {
+		log.Printf("Failed to subscribe to %s: %v", connectionsTopic, err)
+	}
+}
+
+// brokerRestarted forgets the tracked clients, which were disconnected by
+// the restart.
+func (collector *ConnectionsCollector) brokerRestarted() {
+	collector.mu.Lock()
+	clear(collector.Clients)
+	collector.mu.Unlock()
+}
+
+// ListClients returns the tracked clients sorted by client ID.
+func (collector *ConnectionsCollector) ListClients() []ClientInfo {
+	collector.mu.RLock()
+	defer collector.mu.RUnlock()
+	clients := make([]ClientInfo, 0, len(collector.Clients))
+	for _, client := range collector.sortedClients() {
+		clients = append(clients, *client)
+	}
+	return clients
+}
+
+func (collector *ConnectionsCollector) logHandler(client Client, message Message) {
+	line := string(message.Payload())
+	if match := clientConnectedRegexp.FindStringSubmatch(line); match != nil {
+		address := match[1]
+		if host, _, err := net.SplitHostPort(address); err == nil {
+			address = host
+		}
+		protocolVersion, ok := protocolVersions[match[3]]
+		if !ok {
+			protocolVersion = match[3]
+		}
+		keepAlive, _ := strconv.Atoi(match[4])
+		collector.mu.Lock()
+		collector.Clients[match[2]] = &ClientInfo{
+			Broker:          collector.broker,
+			ClientID:        match[2],
+			Address:         address,
+			ProtocolVersion: protocolVersion,
+			KeepAlive:       keepAlive,
+			Username:        match[5],
+			ConnectedAt:     collector.now(),
+		}
+		collector.mu.Unlock()
+		return
+	}
+	for _, r := range clientDisconnectedRegexps {
+		if match := r.FindStringSubmatch(line); match != nil {
+			collector.mu.Lock()
+			delete(collector.Clients, match[1])
+			collector.mu.Unlock()
+			return
+		}
+	}
+}
+
+// sortedClients must be called with the lock held.
+func (collector *ConnectionsCollector) sortedClients() []*ClientInfo {
+	clients := make([]*ClientInfo, 0, len(collector.Clients))
+	for _, client := range collector.Clients {
+		clients = append(clients, client)
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].ClientID < clients[j].ClientID
+	})
+	return clients
+}
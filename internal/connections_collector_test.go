@@ -0,0 +1,103 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// feedConnectionLogs publishes lines on the log topic of connection events.
+func feedConnectionLogs(collector *ConnectionsCollector, lines ...string) {
+	client := newFakeClient("exporter")
+	collector.Subscribe(client)
+	for _, line := range lines {
+		client.publish("$SYS/broker/log/N", line)
+	}
+}
+
+func TestConnectionsCollector_LogHandler(t *testing.T) {
+	labels := prometheus.Labels{"broker": "test-broker"}
+	collector := NewConnectionsCollector(labels, ConnectionsOptions{})
+	connectedAt := time.Unix(1700000000, 0)
+	collector.now = func() time.Time { return connectedAt }
+
+	feedConnectionLogs(collector,
+		"1700000000: New connection from 10.0.0.1:51234 on port 1883.",
+		"1700000000: New client connected from 10.0.0.1:51234 as sensor-1 (p2, c1, k60, u'device').",
+		"1700000000: New client connected from [::1]:40000 as sensor-2 (p5, c0, k30).",
+		"1700000000: New client connected from 10.0.0.3:1234 as sensor-3 (p1, c1, k10).",
+	)
+
+	clients := collector.ListClients()
+	require.Len(t, clients, 3)
+	assert.Equal(t, ClientInfo{
+		Broker:          "test-broker",
+		ClientID:        "sensor-1",
+		Address:         "10.0.0.1",
+		ProtocolVersion: "3.1.1",
+		KeepAlive:       60,
+		Username:        "device",
+		ConnectedAt:     connectedAt,
+	}, clients[0])
+	assert.Equal(t, "::1", clients[1].Address)
+	assert.Equal(t, "5.0", clients[1].ProtocolVersion)
+	assert.Empty(t, clients[1].Username)
+
+	feedConnectionLogs(collector,
+		"1700000001: Client sensor-1 disconnected.",
+		"1700000001: Socket error on client sensor-2, disconnecting.",
+	)
+	clients = collector.ListClients()
+	require.Len(t, clients, 1)
+	assert.Equal(t, "sensor-3", clients[0].ClientID)
+
+	feedConnectionLogs(collector, "1700000002: Client sensor-3 has exceeded timeout, disconnecting.")
+	assert.Empty(t, collector.ListClients())
+
+	// A broker restart disconnected every client
+	feedConnectionLogs(collector, "1700000003: New client connected from 10.0.0.1:51234 as sensor-1 (p2, c1, k60).")
+	collector.brokerRestarted()
+	assert.Empty(t, collector.ListClients())
+}
+
+func TestConnectionsCollector_Collect(t *testing.T) {
+	labels := prometheus.Labels{"broker": "test-broker"}
+
+	// Without info metrics only the number of tracked clients is exported
+	collector := NewConnectionsCollector(labels, ConnectionsOptions{})
+	feedConnectionLogs(collector, "1700000000: New client connected from 10.0.0.1:51234 as sensor-1 (p2, c1, k60).")
+	count, err := testutil.GatherAndCount(registryWith(t, collector))
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	collector = NewConnectionsCollector(labels, ConnectionsOptions{
+		InfoMetrics:  true,
+		MaxSeries:    2,
+		RegexpFilter: RegexpFilter{Allow: "^sensor-", Deny: "-debug$"},
+	})
+	feedConnectionLogs(collector,
+		"1700000000: New client connected from 10.0.0.1:1 as sensor-1 (p2, c1, k60).",
+		"1700000000: New client connected from 10.0.0.2:1 as sensor-2 (p2, c1, k60).",
+		"1700000000: New client connected from 10.0.0.3:1 as sensor-3 (p2, c1, k60).",
+		"1700000000: New client connected from 10.0.0.4:1 as sensor-0-debug (p2, c1, k60).",
+		"1700000000: New client connected from 10.0.0.5:1 as gateway (p2, c1, k60).",
+	)
+	registry := registryWith(t, collector)
+	count, err = testutil.GatherAndCount(registry, "mosquitto_client_info")
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	count, err = testutil.GatherAndCount(registry, "mosquitto_client_connected_timestamp_seconds")
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, []float64{5}, gatherValues(t, registry, "mosquitto_tracked_clients"))
+}
+
+func TestConnectionsOptions_Validate(t *testing.T) {
+	assert.NoError(t, ConnectionsOptions{RegexpFilter: RegexpFilter{Allow: "^sensor-"}}.validate())
+	assert.Error(t, ConnectionsOptions{RegexpFilter: RegexpFilter{Deny: "("}}.validate())
+	assert.Error(t, ConnectionsOptions{MaxSeries: -1}.validate())
+}
@@ -0,0 +1,125 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+const defaultControlInterval = time.Minute
+
+// controlCommand is a command sent to a Mosquitto control API topic such as
+// '$CONTROL/dynamic-security/v1'.
+type controlCommand struct {
+	Command string `json:"command"`
+	Verbose bool   `json:"verbose,omitempty"`
+}
+
+type controlRequest struct {
+	Commands []controlCommand `json:"commands"`
+}
+
+// controlResponse is the answer to a single command.
+type controlResponse struct {
+	Command string          `json:"command"`
+	Error   string          `json:"error"`
+	Data    json.RawMessage `json:"data"`
+}
+
+type controlResponses struct {
+	Responses []controlResponse `json:"responses"`
+}
+
+// controlPoller periodically sends commands to a control API topic and hands
+// the responses published on '<topic>/response' to a callback.
+type controlPoller struct {
+	topic      string
+	commands   []controlCommand
+	interval   time.Duration
+	onResponse func(response controlResponse)
+	onError    func(command string, err string)
+
+	once sync.Once
+	done chan struct{}
+}
+
+func newControlPoller(topic string, interval time.Duration, commands []controlCommand, onResponse func(controlResponse), onError func(string, string)) *controlPoller {
+	if interval <= 0 {
+		interval = defaultControlInterval
+	}
+	return &controlPoller{
+		topic:      topic,
+		commands:   commands,
+		interval:   interval,
+		onResponse: onResponse,
+		onError:    onError,
+		done:       make(chan struct{}),
+	}
+}
+
+// subscribe subscribes to the response topic and starts sending the commands
+// on the first call. It is safe to call it on every reconnection.
+func (p *controlPoller) subscribe(client Client) {
+	responseTopic := p.topic + "/response"
+	if err := client.Subscribe(responseTopic, 0, p.responseHandler); err != nil {
+		log.Printf("Failed to subscribe to %s: %v", responseTopic, err)
+	}
+	p.once.Do(func() {
+		go p.loop(client)
+	})
+}
+
+// stop ends the periodic requests.
+func (p *controlPoller) stop() {
+	select {
+	case <-p.done:
+	default:
+		close(p.done)
+	}
+}
+
+func (p *controlPoller) loop(client Client) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		p.request(client)
+		select {
+		case <-ticker.C:
+		case <-p.done:
+			return
+		}
+	}
+}
+
+func (p *controlPoller) request(client Client) {
+	if !client.IsConnectionOpen() {
+		return
+	}
+	payload, _ := json.Marshal(controlRequest{Commands: p.commands})
+	// Requests are sent at most once per interval
+	ctx, cancel := context.WithTimeout(context.Background(), p.interval)
+	defer cancel()
+	if err := client.Publish(ctx, p.topic, 1, false, payload); err != nil {
+		log.Printf("Failed to publish to %s: %v", p.topic, err)
+		for _, command := range p.commands {
+			p.onError(command.Command, err.Error())
+		}
+	}
+}
+
+func (p *controlPoller) responseHandler(client Client, message Message) {
+	var responses controlResponses
+	if err := json.Unmarshal(message.Payload(), &responses); err != nil {
+		log.Printf("Invalid response on %s: %v", message.Topic(), err)
+		return
+	}
+	for _, response := range responses.Responses {
+		if response.Error != "" {
+			p.onError(response.Command, response.Error)
+			continue
+		}
+		p.onResponse(response)
+	}
+}
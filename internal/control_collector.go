@@ -0,0 +1,171 @@
+package internal
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const brokerControlTopic = "$CONTROL/broker/v1"
+
+// ControlOptions configures the broker control API collector.
+type ControlOptions struct {
+	// Interval is the period of the listListeners and listPlugins requests,
+	// one minute by default. The API is reported missing after two periods
+	// without answer.
+	Interval time.Duration `yaml:"interval"`
+}
+
+func (o ControlOptions) validate() error {
+	if o.Interval < 0 {
+		return errNegativeDuration("control.interval")
+	}
+	return nil
+}
+
+type controlListener struct {
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"`
+	TLS      bool   `json:"tls"`
+}
+
+type controlPlugin struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// ControlCollector lists the listeners and plugins of the broker through the
+// '$CONTROL/broker/v1' API. Brokers that do not implement it never answer,
+// in which case only mosquitto_control_api_supported is exported.
+type ControlCollector struct {
+	mu           sync.RWMutex
+	Listeners    []controlListener
+	Plugins      []controlPlugin
+	lastResponse time.Time
+	interval     time.Duration
+	descriptions map[string]metric
+	poller       *controlPoller
+	now          func() time.Time
+}
+
+func init() {
+	registerCollector("control", false, "Enable the broker control API collector, exposing listeners and plugins.", func(labels prometheus.Labels, options CollectorOptions) Collector {
+		return NewControlCollector(labels, options.Control)
+	})
+}
+
+func NewControlCollector(labels prometheus.Labels, options ControlOptions) *ControlCollector {
+	collector := &ControlCollector{
+		mu: sync.RWMutex{},
+		descriptions: map[string]metric{
+			"supported": {
+				desc:      prometheus.NewDesc("mosquitto_control_api_supported", "Whether the broker answers on the $CONTROL/broker/v1 API", nil, labels),
+				valueType: prometheus.GaugeValue,
+			},
+			"listener": {
+				desc:      prometheus.NewDesc("mosquitto_listener_info", "Information about a listener of the broker", []string{"port", "protocol", "tls"}, labels),
+				valueType: prometheus.GaugeValue,
+			},
+			"plugin": {
+				desc:      prometheus.NewDesc("mosquitto_plugin_info", "Information about a plugin loaded by the broker", []string{"name", "version"}, labels),
+				valueType: prometheus.GaugeValue,
+			},
+		},
+		now: time.Now,
+	}
+	collector.poller = newControlPoller(brokerControlTopic, options.Interval, []controlCommand{
+		{Command: "listListeners"},
+		{Command: "listPlugins"},
+	}, collector.handleResponse, func(command string, err string) {
+		log.Printf("Broker control command %s failed: %s", command, err)
+	})
+	collector.interval = collector.poller.interval
+	return collector
+}
+
+func (collector *ControlCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range collector.descriptions {
+		ch <- desc.desc
+	}
+}
+
+func (collector *ControlCollector) Collect(ch chan<- prometheus.Metric) {
+	collector.mu.RLock()
+	defer collector.mu.RUnlock()
+
+	supported := collector.supported()
+	desc := collector.descriptions["supported"]
+	ch <- prometheus.MustNewConstMetric(desc.desc, desc.valueType, boolToFloat(supported))
+	if !supported {
+		return
+	}
+
+	// The listeners bound to different addresses on the same port, the unix
+	// socket listeners and the plugins loaded on several listeners share
+	// the same labels, they are exported once.
+	listener := collector.descriptions["listener"]
+	listeners := make(map[controlListener]bool, len(collector.Listeners))
+	for _, l := range collector.Listeners {
+		if listeners[l] {
+			continue
+		}
+		listeners[l] = true
+		ch <- prometheus.MustNewConstMetric(listener.desc, listener.valueType, 1,
+			strconv.Itoa(l.Port), l.Protocol, strconv.FormatBool(l.TLS))
+	}
+	plugin := collector.descriptions["plugin"]
+	plugins := make(map[controlPlugin]bool, len(collector.Plugins))
+	for _, p := range collector.Plugins {
+		if plugins[p] {
+			continue
+		}
+		plugins[p] = true
+		ch <- prometheus.MustNewConstMetric(plugin.desc, plugin.valueType, 1, p.Name, p.Version)
+	}
+}
+
+func (collector *ControlCollector) Subscribe(client Client) {
+	collector.poller.subscribe(client)
+}
+
+// Stop ends the requests to the broker control API.
+func (collector *ControlCollector) Stop() {
+	collector.poller.stop()
+}
+
+// supported must be called with the lock held. The API is considered missing
+// when the broker did not answer the last two requests.
+func (collector *ControlCollector) supported() bool {
+	if collector.lastResponse.IsZero() {
+		return false
+	}
+	return collector.now().Sub(collector.lastResponse) <= 2*collector.interval
+}
+
+func (collector *ControlCollector) handleResponse(response controlResponse) {
+	var data struct {
+		Listeners []controlListener `json:"listeners"`
+		Plugins   []controlPlugin   `json:"plugins"`
+	}
+	if err := json.Unmarshal(response.Data, &data); err != nil {
+		log.Printf("Invalid %s response: %v", response.Command, err)
+		return
+	}
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	switch response.Command {
+	case "listListeners":
+		collector.Listeners = data.Listeners
+	case "listPlugins":
+		collector.Plugins = data.Plugins
+	default:
+		log.Printf("Unexpected broker control response: %s", response.Command)
+		return
+	}
+	collector.lastResponse = collector.now()
+}
@@ -0,0 +1,64 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewControlCollector(t *testing.T) {
+	labels := prometheus.Labels{"broker": "test-broker"}
+	collector := NewControlCollector(labels, ControlOptions{})
+
+	assert.NotNil(t, collector)
+	assert.Equal(t, 3, len(collector.descriptions))
+	assert.Equal(t, defaultControlInterval, collector.interval)
+}
+
+func TestControlCollector_Responses(t *testing.T) {
+	labels := prometheus.Labels{"broker": "test-broker"}
+	collector := NewControlCollector(labels, ControlOptions{Interval: time.Minute})
+	client := newFakeClient("exporter")
+	collector.Subscribe(client)
+	defer collector.Stop()
+	now := time.Now()
+	collector.now = func() time.Time { return now }
+	registry := registryWith(t, collector)
+
+	// Unsupported until the broker answered
+	assert.Equal(t, []float64{0}, gatherValues(t, registry, "mosquitto_control_api_supported"))
+
+	client.publish("$CONTROL/broker/v1/response", `{"responses":[
+			{"command":"listListeners","data":{"listeners":[
+				{"port":1883,"protocol":"mqtt","tls":false},
+				{"port":1883,"protocol":"mqtt","tls":false},
+				{"port":8883,"protocol":"mqtt","tls":true},
+				{"port":8080,"protocol":"websockets","tls":false},
+				{"port":0,"protocol":"mqtt","tls":false},
+				{"port":0,"protocol":"mqtt","tls":false}
+			]}},
+			{"command":"listPlugins","data":{"plugins":[
+				{"name":"dynamic-security","version":"2.1.0","control-endpoints":["$CONTROL/dynamic-security/v1"]},
+				{"name":"dynamic-security","version":"2.1.0","control-endpoints":["$CONTROL/dynamic-security/v1"]}
+			]}}
+		]}`)
+
+	assert.Equal(t, []float64{1}, gatherValues(t, registry, "mosquitto_control_api_supported"))
+	count, err := testutil.GatherAndCount(registry, "mosquitto_listener_info", "mosquitto_plugin_info")
+	require.NoError(t, err)
+	// Listeners and plugins with the same labels are exported once
+	assert.Equal(t, 5, count)
+
+	// The broker stopped answering
+	now = now.Add(3 * time.Minute)
+	assert.Equal(t, []float64{0}, gatherValues(t, registry, "mosquitto_control_api_supported"))
+	count, err = testutil.GatherAndCount(registry, "mosquitto_listener_info", "mosquitto_plugin_info")
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	assert.Error(t, ControlOptions{Interval: -time.Second}.validate())
+}
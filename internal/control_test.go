@@ -0,0 +1,47 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewControlPoller(t *testing.T) {
+	poller := newControlPoller("$CONTROL/test/v1", 0, nil, func(controlResponse) {}, func(string, string) {})
+	assert.Equal(t, defaultControlInterval, poller.interval)
+
+	poller = newControlPoller("$CONTROL/test/v1", 5*time.Second, nil, func(controlResponse) {}, func(string, string) {})
+	assert.Equal(t, 5*time.Second, poller.interval)
+
+	// Stopping twice must not panic
+	poller.stop()
+	poller.stop()
+}
+
+func TestControlPoller_ResponseHandler(t *testing.T) {
+	var responses []controlResponse
+	errors := map[string]string{}
+	poller := newControlPoller("$CONTROL/test/v1", 0, nil, func(response controlResponse) {
+		responses = append(responses, response)
+	}, func(command string, err string) {
+		errors[command] = err
+	})
+	client := newFakeClient("exporter")
+	poller.subscribe(client)
+	defer poller.stop()
+
+	client.publish("$CONTROL/test/v1/response", `{"responses":[{"command":"listThings","data":{"things":[]}},{"command":"getThing","error":"Permission denied"}]}`)
+	// Invalid payloads are ignored
+	client.publish("$CONTROL/test/v1/response", "not json")
+
+	if assert.Len(t, responses, 1) {
+		assert.Equal(t, "listThings", responses[0].Command)
+		assert.JSONEq(t, `{"things":[]}`, string(responses[0].Data))
+	}
+	assert.Equal(t, map[string]string{"getThing": "Permission denied"}, errors)
+	// The commands are sent as soon as the poller subscribed
+	assert.Eventually(t, func() bool {
+		return len(client.publishedOn("$CONTROL/test/v1")) == 1
+	}, time.Second, 10*time.Millisecond)
+}
@@ -2,113 +2,191 @@ package internal
 
 import (
 	"log"
-	"strconv"
-	"strings"
 	"sync"
+	"time"
 
-	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
 type defaultMetrics struct {
-	uptime              float64
-	version             string
-	subscriptions       float64
-	sharedSubscriptions float64
+	uptime  float64
+	version string
+	// startTime is the earliest start time computed from the uptime, the
+	// retained uptime delivered on subscription being late.
+	startTime   time.Time
+	restarts    float64
+	lastRestart time.Time
 }
 
+// DefaultCollector exports the values of the default table and detects
+// broker restarts from the uptime and version.
 type DefaultCollector struct {
-	descriptions map[string]metric
-	mu           sync.RWMutex
-	Metrics      *defaultMetrics
+	values           *SysCollector
+	descriptions     map[string]metric
+	mu               sync.RWMutex
+	Metrics          *defaultMetrics
+	uptimeListeners  []func(uptime float64)
+	restartListeners []func()
+	now              func() time.Time
+}
+
+func init() {
+	registerCollector("default", true, "Enable the default collector (uptime, version, subscription counts and broker restarts).", func(labels prometheus.Labels, options CollectorOptions) Collector {
+		return NewDefaultCollector(labels)
+	})
 }
 
 func NewDefaultCollector(labels prometheus.Labels) *DefaultCollector {
-	return &DefaultCollector{
+	collector := &DefaultCollector{
+		values:  NewSysCollector(labels, defaultTable),
 		mu:      sync.RWMutex{},
 		Metrics: &defaultMetrics{},
 		descriptions: map[string]metric{
-			"uptime": {
-				desc:      prometheus.NewDesc("mosquitto_uptime_seconds", "Seconds since the broker was started", nil, labels),
-				valueType: prometheus.CounterValue,
-			},
-			"version": {
-				desc:      prometheus.NewDesc("mosquitto_version_info", "Mosquitto version", []string{"version"}, labels),
+			"start_time": {
+				desc:      prometheus.NewDesc("mosquitto_broker_start_time_seconds", "Time the broker was started, computed from its uptime", nil, labels),
 				valueType: prometheus.GaugeValue,
 			},
-			"subscriptions_total": {
-				desc:      prometheus.NewDesc("mosquitto_subscriptions_total", "Number of active subscriptions", nil, labels),
-				valueType: prometheus.GaugeValue,
+			"restarts_total": {
+				desc:      prometheus.NewDesc("mosquitto_broker_restarts_total", "Number of broker restarts detected from its uptime and version", nil, labels),
+				valueType: prometheus.CounterValue,
 			},
-			"shared_subscriptions_total": {
-				desc:      prometheus.NewDesc("mosquitto_shared_subscriptions_total", "Number of active shared subscriptions", nil, labels),
+			"last_restart": {
+				desc:      prometheus.NewDesc("mosquitto_broker_last_restart_timestamp_seconds", "Time the last broker restart was detected", nil, labels),
 				valueType: prometheus.GaugeValue,
 			},
 		},
+		now: time.Now,
 	}
+	collector.values.observe = collector.observe
+	return collector
 }
 
 func (collector *DefaultCollector) Describe(ch chan<- *prometheus.Desc) {
+	collector.values.Describe(ch)
 	for _, desc := range collector.descriptions {
 		ch <- desc.desc
 	}
 }
 
 func (collector *DefaultCollector) Collect(ch chan<- prometheus.Metric) {
+	collector.values.Collect(ch)
+	// The start time is computed from the uptime and goes stale with it
+	collector.values.mu.RLock()
+	uptime, ok := collector.values.Values[uptimeTopic]
+	fresh := ok && collector.values.sys.fresh(uptime.updated)
+	collector.values.mu.RUnlock()
 
 	collector.mu.RLock()
-	ch <- prometheus.MustNewConstMetric(collector.descriptions["uptime"].desc, collector.descriptions["uptime"].valueType, collector.Metrics.uptime)
-	ch <- prometheus.MustNewConstMetric(collector.descriptions["version"].desc, collector.descriptions["version"].valueType, 1, collector.Metrics.version)
-	ch <- prometheus.MustNewConstMetric(collector.descriptions["subscriptions_total"].desc, collector.descriptions["subscriptions_total"].valueType, collector.Metrics.subscriptions)
-	ch <- prometheus.MustNewConstMetric(collector.descriptions["shared_subscriptions_total"].desc, collector.descriptions["shared_subscriptions_total"].valueType, collector.Metrics.sharedSubscriptions)
+	ch <- prometheus.MustNewConstMetric(collector.descriptions["restarts_total"].desc, collector.descriptions["restarts_total"].valueType, collector.Metrics.restarts)
+	if fresh && !collector.Metrics.startTime.IsZero() {
+		ch <- prometheus.MustNewConstMetric(collector.descriptions["start_time"].desc, collector.descriptions["start_time"].valueType, float64(collector.Metrics.startTime.UnixNano())/1e9)
+	}
+	if !collector.Metrics.lastRestart.IsZero() {
+		ch <- prometheus.MustNewConstMetric(collector.descriptions["last_restart"].desc, collector.descriptions["last_restart"].valueType, float64(collector.Metrics.lastRestart.UnixNano())/1e9)
+	}
 	collector.mu.RUnlock()
 }
 
-func (collector *DefaultCollector) Subscribe(client mqtt.Client) {
-	if token := client.Subscribe("$SYS/broker/uptime", 0, collector.uptimeHandler); token.Wait() && token.Error() != nil {
-		log.Printf("Failed to subscribe to $SYS/broker/uptime: %v", token.Error())
-		SubscriptionErrors.WithLabelValues("$SYS/broker/uptime", token.Error().Error()).Inc()
+func (collector *DefaultCollector) Subscribe(client Client) {
+	collector.values.Subscribe(client)
+}
+
+// setSysTracker makes the values of the default table go stale and have an
+// age like the other $SYS values.
+func (collector *DefaultCollector) setSysTracker(tracker *SysTracker) {
+	collector.values.setSysTracker(tracker)
+}
+
+// observe follows the uptime and version of the broker, before their values
+// are stored.
+func (collector *DefaultCollector) observe(topic string, value *sysValue) {
+	switch topic {
+	case uptimeTopic:
+		collector.uptimeReceived(value.value)
+	case versionTopic:
+		collector.versionReceived(value.labelValues[len(value.labelValues)-1])
 	}
-	if token := client.Subscribe("$SYS/broker/version", 0, collector.versionHandler); token.Wait() && token.Error() != nil {
-		log.Printf("Failed to subscribe to $SYS/broker/version: %v", token.Error())
-		SubscriptionErrors.WithLabelValues("$SYS/broker/version", token.Error().Error()).Inc()
+}
+
+func (collector *DefaultCollector) uptimeReceived(uptime float64) {
+	now := collector.now()
+	collector.mu.Lock()
+	// The uptime of a broker that restarted goes backwards
+	restarted := uptime < collector.Metrics.uptime
+	if restarted {
+		collector.restarted(now)
 	}
-	if token := client.Subscribe("$SYS/broker/subscriptions/count", 0, collector.subscriptionsHandler); token.Wait() && token.Error() != nil {
-		log.Printf("Failed to subscribe to $SYS/broker/subscriptions/count: %v", token.Error())
-		SubscriptionErrors.WithLabelValues("$SYS/broker/subscriptions/count", token.Error().Error()).Inc()
+	collector.Metrics.uptime = uptime
+	startTime := now.Add(-time.Duration(uptime) * time.Second)
+	if collector.Metrics.startTime.IsZero() || startTime.Before(collector.Metrics.startTime) {
+		collector.Metrics.startTime = startTime
 	}
-	if token := client.Subscribe("$SYS/broker/shared_subscriptions/count", 0, collector.sharedSubscriptionsHandler); token.Wait() && token.Error() != nil {
-		log.Printf("Failed to subscribe to $SYS/broker/shared_subscriptions/count: %v", token.Error())
-		SubscriptionErrors.WithLabelValues("$SYS/broker/shared_subscriptions/count", token.Error().Error()).Inc()
+	uptimeListeners := collector.uptimeListeners
+	restartListeners := collector.restartListeners
+	collector.mu.Unlock()
+	if restarted {
+		for _, listener := range restartListeners {
+			listener()
+		}
+	}
+	for _, listener := range uptimeListeners {
+		listener(uptime)
 	}
 }
 
-func (collector *DefaultCollector) uptimeHandler(client mqtt.Client, message mqtt.Message) {
-	// Payload is 'XXX seconds'
-	uptime, _ := strconv.Atoi(strings.Split(string(message.Payload()), " ")[0])
-	collector.mu.Lock()
-	collector.Metrics.uptime = float64(uptime)
-	collector.mu.Unlock()
+// restarted records a broker restart detected at now and forgets the values
+// of the previous broker run. collector.mu must be held.
+func (collector *DefaultCollector) restarted(now time.Time) {
+	log.Printf("Broker restart detected")
+	collector.Metrics.restarts++
+	collector.Metrics.lastRestart = now
+	collector.Metrics.startTime = time.Time{}
+	collector.Metrics.version = ""
+	// The uptime is the one of the new run, either received first or being
+	// received
+	collector.values.mu.Lock()
+	for topic := range collector.values.Values {
+		if topic != uptimeTopic {
+			delete(collector.values.Values, topic)
+		}
+	}
+	collector.values.mu.Unlock()
 }
 
-func (collector *DefaultCollector) versionHandler(client mqtt.Client, message mqtt.Message) {
-	// Payload is 'mosquitto version X.X.X'
-	version := strings.Split(string(message.Payload()), " ")[2]
+// OnUptime registers f to be called every time the broker publishes its
+// uptime, which happens once per $SYS interval.
+func (collector *DefaultCollector) OnUptime(f func(uptime float64)) {
 	collector.mu.Lock()
-	collector.Metrics.version = version
+	collector.uptimeListeners = append(collector.uptimeListeners, f)
 	collector.mu.Unlock()
 }
 
-func (collector *DefaultCollector) subscriptionsHandler(client mqtt.Client, message mqtt.Message) {
-	num, _ := strconv.Atoi(string(message.Payload()))
+// OnRestart registers f to be called every time a broker restart is
+// detected, so the values of the previous broker run are not exported.
+func (collector *DefaultCollector) OnRestart(f func()) {
 	collector.mu.Lock()
-	collector.Metrics.subscriptions = float64(num)
+	collector.restartListeners = append(collector.restartListeners, f)
 	collector.mu.Unlock()
 }
 
-func (collector *DefaultCollector) sharedSubscriptionsHandler(client mqtt.Client, message mqtt.Message) {
-	num, _ := strconv.Atoi(string(message.Payload()))
+func (collector *DefaultCollector) versionReceived(version string) {
+	now := collector.now()
 	collector.mu.Lock()
-	collector.Metrics.sharedSubscriptions = float64(num)
+	// The version changes when the broker was upgraded, which the uptime
+	// does not tell if the exporter was disconnected for longer than the
+	// previous run of the broker. The uptime, received first, is already
+	// the one of the new run.
+	restarted := collector.Metrics.version != "" && version != collector.Metrics.version
+	if restarted {
+		collector.restarted(now)
+		collector.Metrics.startTime = now.Add(-time.Duration(collector.Metrics.uptime) * time.Second)
+	}
+	collector.Metrics.version = version
+	listeners := collector.restartListeners
 	collector.mu.Unlock()
+	if restarted {
+		for _, listener := range listeners {
+			listener()
+		}
+	}
 }
@@ -1,28 +1,15 @@
 package internal
 
 import (
-	"strconv"
-	"strings"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-// mockMessage implements mqtt.Message for testing
-type mockMessage struct {
-	payload []byte
-	topic   string
-}
-
-func (m *mockMessage) Duplicate() bool { return false }
-func (m *mockMessage) Qos() byte       { return 0 }
-func (m *mockMessage) Retained() bool  { return false }
-func (m *mockMessage) Topic() string   { return m.topic }
-func (m *mockMessage) MessageID() uint16 { return 0 }
-func (m *mockMessage) Payload() []byte { return m.payload }
-func (m *mockMessage) Ack()            {}
-
 func TestNewDefaultCollector(t *testing.T) {
 	labels := prometheus.Labels{"broker": "test-broker"}
 	collector := NewDefaultCollector(labels)
@@ -30,7 +17,7 @@ func TestNewDefaultCollector(t *testing.T) {
 	assert.NotNil(t, collector)
 	assert.NotNil(t, collector.Metrics)
 	assert.NotNil(t, collector.descriptions)
-	assert.Equal(t, 4, len(collector.descriptions))
+	assert.Equal(t, 3, len(collector.descriptions))
 }
 
 func TestDefaultCollector_Describe(t *testing.T) {
@@ -48,18 +35,19 @@ func TestDefaultCollector_Describe(t *testing.T) {
 		count++
 	}
 
-	assert.Equal(t, 4, count)
+	assert.Equal(t, 7, count)
 }
 
 func TestDefaultCollector_Collect(t *testing.T) {
 	labels := prometheus.Labels{"broker": "test-broker"}
 	collector := NewDefaultCollector(labels)
+	client := newFakeClient("exporter")
+	collector.Subscribe(client)
 
-	// Set some test values
-	collector.Metrics.uptime = 123.45
-	collector.Metrics.version = "2.0.15"
-	collector.Metrics.subscriptions = 10
-	collector.Metrics.sharedSubscriptions = 5
+	client.publish("$SYS/broker/uptime", "123 seconds")
+	client.publish("$SYS/broker/version", "mosquitto version 2.0.15")
+	client.publish("$SYS/broker/subscriptions/count", "10")
+	client.publish("$SYS/broker/shared_subscriptions/count", "5")
 
 	metrics := make(chan prometheus.Metric)
 	go func() {
@@ -72,77 +60,131 @@ func TestDefaultCollector_Collect(t *testing.T) {
 		count++
 	}
 
-	assert.Equal(t, 4, count)
-}
-
-func TestDefaultCollector_UptimeHandler(t *testing.T) {
-	// Test the parsing logic directly
-	payload := []byte("12345 seconds")
-	uptime, _ := strconv.Atoi(strings.Split(string(payload), " ")[0])
-	assert.Equal(t, 12345, uptime)
-}
-
-func TestDefaultCollector_VersionHandler(t *testing.T) {
-	// Test the parsing logic directly
-	payload := []byte("mosquitto version 2.0.15")
-	version := strings.Split(string(payload), " ")[2]
-	assert.Equal(t, "2.0.15", version)
-}
-
-func TestDefaultCollector_SubscriptionsHandler(t *testing.T) {
-	// Test the parsing logic directly
-	payload := []byte("42")
-	num, _ := strconv.Atoi(string(payload))
-	assert.Equal(t, 42, num)
+	// No restart was detected yet
+	assert.Equal(t, 6, count)
 }
 
-func TestDefaultCollector_SharedSubscriptionsHandler(t *testing.T) {
-	// Test the parsing logic directly
-	payload := []byte("24")
-	num, _ := strconv.Atoi(string(payload))
-	assert.Equal(t, 24, num)
+func TestDefaultCollector_Stale(t *testing.T) {
+	labels := prometheus.Labels{"broker": "test-broker"}
+	now := time.Unix(1700000000, 0)
+	tracker := NewSysTracker(labels, SysOptions{StaleIntervals: 3})
+	tracker.now = func() time.Time { return now }
+	collector := NewDefaultCollector(labels)
+	collector.now = tracker.now
+	collector.OnUptime(tracker.observeUptime)
+	collector.setSysTracker(tracker)
+	client := newFakeClient("exporter")
+	collector.Subscribe(client)
+	registry := registryWith(t, collector)
+	require.NoError(t, registry.Register(tracker))
+
+	client.publish("$SYS/broker/version", "mosquitto version 2.0.15")
+	client.publish("$SYS/broker/uptime", "100 seconds")
+	now = now.Add(10 * time.Second)
+	client.publish("$SYS/broker/uptime", "110 seconds")
+	assert.Equal(t, []float64{110}, gatherValues(t, registry, "mosquitto_uptime_seconds"))
+	assert.Equal(t, []float64{1699999900}, gatherValues(t, registry, "mosquitto_broker_start_time_seconds"))
+	assert.Equal(t, []float64{0, 10}, gatherValues(t, registry, "mosquitto_sys_value_age_seconds"))
+
+	// Older than 3 intervals of 10s
+	now = now.Add(time.Minute)
+	assert.Empty(t, gatherValues(t, registry, "mosquitto_uptime_seconds"))
+	assert.Empty(t, gatherValues(t, registry, "mosquitto_version_info"))
+	assert.Empty(t, gatherValues(t, registry, "mosquitto_broker_start_time_seconds"))
+	assert.Equal(t, []float64{0}, gatherValues(t, registry, "mosquitto_broker_restarts_total"))
 }
 
-func TestDefaultCollector_UptimeHandler_Integration(t *testing.T) {
+func TestDefaultCollector_Subscribe(t *testing.T) {
 	labels := prometheus.Labels{"broker": "test-broker"}
 	collector := NewDefaultCollector(labels)
-	
-	// Create mock message with uptime payload
-	msg := &mockMessage{payload: []byte("12345 seconds")}
-	
-	// Call the handler directly
-	collector.uptimeHandler(nil, msg)
-	
-	// Verify the metric was updated
-	assert.Equal(t, float64(12345), collector.Metrics.uptime)
+	client := newFakeClient("exporter")
+	collector.Subscribe(client)
+	registry := registryWith(t, collector)
+
+	client.publish("$SYS/broker/uptime", "12345 seconds")
+	client.publish("$SYS/broker/version", "mosquitto version 2.0.15")
+	client.publish("$SYS/broker/subscriptions/count", "42")
+	client.publish("$SYS/broker/shared_subscriptions/count", "24")
+	// Not subscribed by this collector
+	client.publish("$SYS/broker/clients/connected", "8")
+
+	assert.Equal(t, []float64{12345}, gatherValues(t, registry, "mosquitto_uptime_seconds"))
+	assert.Equal(t, "2.0.15", collector.Metrics.version)
+	assert.Equal(t, []float64{42}, gatherValues(t, registry, "mosquitto_subscriptions_total"))
+	assert.Equal(t, []float64{24}, gatherValues(t, registry, "mosquitto_shared_subscriptions_total"))
+	assert.Len(t, collector.values.Values, 4)
 }
 
-func TestDefaultCollector_VersionHandler_Integration(t *testing.T) {
+func TestDefaultCollector_SubscribeError(t *testing.T) {
 	labels := prometheus.Labels{"broker": "test-broker"}
 	collector := NewDefaultCollector(labels)
-	
-	msg := &mockMessage{payload: []byte("mosquitto version 2.0.15")}
-	collector.versionHandler(nil, msg)
-	
-	assert.Equal(t, "2.0.15", collector.Metrics.version)
+	client := newFakeClient("exporter")
+	client.subscribeErrs["$SYS/broker/version"] = errors.New("not authorized")
+	collector.Subscribe(client)
+
+	client.publish("$SYS/broker/uptime", "10 seconds")
+	client.publish("$SYS/broker/version", "mosquitto version 2.0.15")
+
+	assert.Equal(t, float64(10), collector.Metrics.uptime)
+	assert.Empty(t, collector.Metrics.version)
 }
 
-func TestDefaultCollector_SubscriptionsHandler_Integration(t *testing.T) {
+func TestDefaultCollector_OnUptime(t *testing.T) {
 	labels := prometheus.Labels{"broker": "test-broker"}
 	collector := NewDefaultCollector(labels)
-	
-	msg := &mockMessage{payload: []byte("42")}
-	collector.subscriptionsHandler(nil, msg)
-	
-	assert.Equal(t, float64(42), collector.Metrics.subscriptions)
+	client := newFakeClient("exporter")
+	collector.Subscribe(client)
+
+	var received []float64
+	collector.OnUptime(func(uptime float64) {
+		received = append(received, uptime)
+	})
+	client.publish("$SYS/broker/uptime", "10 seconds")
+	client.publish("$SYS/broker/uptime", "20 seconds")
+
+	assert.Equal(t, []float64{10, 20}, received)
 }
 
-func TestDefaultCollector_SharedSubscriptionsHandler_Integration(t *testing.T) {
+func TestDefaultCollector_Restart(t *testing.T) {
 	labels := prometheus.Labels{"broker": "test-broker"}
 	collector := NewDefaultCollector(labels)
-	
-	msg := &mockMessage{payload: []byte("24")}
-	collector.sharedSubscriptionsHandler(nil, msg)
-	
-	assert.Equal(t, float64(24), collector.Metrics.sharedSubscriptions)
-}
\ No newline at end of file
+	now := time.Unix(1700000000, 0)
+	collector.now = func() time.Time { return now }
+	client := newFakeClient("exporter")
+	collector.Subscribe(client)
+	registry := registryWith(t, collector)
+	restarts := 0
+	collector.OnRestart(func() { restarts++ })
+
+	client.publish("$SYS/broker/uptime", "100 seconds")
+	client.publish("$SYS/broker/version", "mosquitto version 2.0.15")
+	client.publish("$SYS/broker/subscriptions/count", "42")
+	now = now.Add(10 * time.Second)
+	client.publish("$SYS/broker/uptime", "110 seconds")
+	assert.Equal(t, []float64{1699999900}, gatherValues(t, registry, "mosquitto_broker_start_time_seconds"))
+	assert.Equal(t, []float64{0}, gatherValues(t, registry, "mosquitto_broker_restarts_total"))
+	assert.Empty(t, gatherValues(t, registry, "mosquitto_broker_last_restart_timestamp_seconds"))
+
+	// The uptime goes backwards
+	now = now.Add(time.Minute)
+	client.publish("$SYS/broker/uptime", "5 seconds")
+	assert.Equal(t, 1, restarts)
+	assert.Equal(t, []float64{1700000065}, gatherValues(t, registry, "mosquitto_broker_start_time_seconds"))
+	assert.Equal(t, []float64{1}, gatherValues(t, registry, "mosquitto_broker_restarts_total"))
+	assert.Equal(t, []float64{1700000070}, gatherValues(t, registry, "mosquitto_broker_last_restart_timestamp_seconds"))
+	assert.Empty(t, gatherValues(t, registry, "mosquitto_subscriptions_total"))
+	assert.Equal(t, []float64{5}, gatherValues(t, registry, "mosquitto_uptime_seconds"))
+	// The version of the new run is not a restart again
+	client.publish("$SYS/broker/version", "mosquitto version 2.0.18")
+	assert.Equal(t, 1, restarts)
+
+	// The version changes while the exporter missed the uptime going backwards
+	now = now.Add(time.Hour)
+	client.publish("$SYS/broker/uptime", "600 seconds")
+	client.publish("$SYS/broker/version", "mosquitto version 2.0.20")
+	assert.Equal(t, 2, restarts)
+	assert.Equal(t, []float64{2}, gatherValues(t, registry, "mosquitto_broker_restarts_total"))
+	assert.Equal(t, []float64{1700003070}, gatherValues(t, registry, "mosquitto_broker_start_time_seconds"))
+	assert.Equal(t, "2.0.20", collector.Metrics.version)
+	assert.Equal(t, []float64{600}, gatherValues(t, registry, "mosquitto_uptime_seconds"))
+}
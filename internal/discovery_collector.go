@@ -0,0 +1,148 @@
+package internal
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const discoveryTopic = "$SYS/#"
+
+var invalidMetricNameRegexp = regexp.MustCompile(`[^a-zA-Z0-9_]+`)
+
+// DiscoveryOptions configures the $SYS discovery collector.
+type DiscoveryOptions struct {
+	// RegexpFilter selects the discovered values by their topic.
+	RegexpFilter `yaml:",inline"`
+	// MaxSeries caps the number of discovered metrics, 0 for no limit.
+	MaxSeries int `yaml:"max_series"`
+}
+
+func (o DiscoveryOptions) validate() error {
+	if o.MaxSeries < 0 {
+		return fmt.Errorf("discovery.max_series must not be negative")
+	}
+	if err := o.RegexpFilter.validate(); err != nil {
+		return fmt.Errorf("discovery: %w", err)
+	}
+	return nil
+}
+
+type discoveredValue struct {
+	desc  *prometheus.Desc
+	value float64
+}
+
+// DiscoveryCollector exports every numeric $SYS value no dedicated
+// collector reads as a gauge named after its topic, so the values added by
+// new Mosquitto releases are not lost.
+type DiscoveryCollector struct {
+	mu      sync.RWMutex
+	Values  map[string]*discoveredValue
+	names   map[string]string
+	labels  prometheus.Labels
+	options DiscoveryOptions
+	topics  regexpMatcher
+	// excluded are the parts of the $SYS tree read by other collectors
+	excluded []string
+}
+
+func init() {
+	registerCollector("discovery", false, "Enable the $SYS discovery collector, exporting the numeric $SYS values no other collector reads.", func(labels prometheus.Labels, options CollectorOptions) Collector {
+		return NewDiscoveryCollector(labels, options.Discovery, options.Sys.Mappings)
+	})
+}
+
+// NewDiscoveryCollector creates a discovery collector leaving the $SYS
+// values of mappings to their collector. The parts of the tree read by the
+// dedicated collectors are left to them whether they are enabled or not, so
+// the discovered metrics do not change when they are.
+func NewDiscoveryCollector(labels prometheus.Labels, options DiscoveryOptions, mappings []SysMapping) *DiscoveryCollector {
+	collector := &DiscoveryCollector{
+		mu:       sync.RWMutex{},
+		Values:   make(map[string]*discoveredValue, 32),
+		names:    make(map[string]string, 32),
+		labels:   labels,
+		options:  options,
+		topics:   options.RegexpFilter.compile(),
+		excluded: append(builtinSysFilters(), bridgeStateTopic, logTopic),
+	}
+	collector.excluded = append(collector.excluded, SysTable{Mappings: mappings}.filters()...)
+	return collector
+}
+
+// Describe sends nothing, the discovered metrics are not known in advance.
+// This makes the collector unchecked: the registry does not check its
+// metrics when it is registered, the names derived from the topics are
+// checked against each other instead.
+func (collector *DiscoveryCollector) Describe(ch chan<- *prometheus.Desc) {
+}
+
+func (collector *DiscoveryCollector) Collect(ch chan<- prometheus.Metric) {
+	collector.mu.RLock()
+	defer collector.mu.RUnlock()
+	for _, v := range collector.Values {
+		ch <- prometheus.MustNewConstMetric(v.desc, prometheus.GaugeValue, v.value)
+	}
+}
+
+func (collector *DiscoveryCollector) Subscribe(client Client) {
+	if err := client.Subscribe(discoveryTopic, 0, collector.discoveryHandler); err != nil {
+		log.Printf("Failed to subscribe to %s: %v", discoveryTopic, err)
+	}
+}
+
+// brokerRestarted forgets the values published by the broker before it
+// restarted.
+func (collector *DiscoveryCollector) brokerRestarted() {
+	collector.mu.Lock()
+	clear(collector.Values)
+	clear(collector.names)
+	collector.mu.Unlock()
+}
+
+func (collector *DiscoveryCollector) discoveryHandler(client Client, message Message) {
+	topic := message.Topic()
+	if firstMatchingFilter(collector.excluded, topic) >= 0 || !collector.topics.matches(topic) {
+		return
+	}
+	value, err := strconv.ParseFloat(strings.TrimSpace(string(message.Payload())), 64)
+	if err != nil {
+		return
+	}
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	v, ok := collector.Values[topic]
+	if !ok {
+		if collector.options.MaxSeries > 0 && len(collector.Values) >= collector.options.MaxSeries {
+			return
+		}
+		name := sysTopicMetricName(topic)
+		if other, ok := collector.names[name]; ok {
+			log.Printf("Ignoring $SYS topic %q, its metric name %s is the one of %q", topic, name, other)
+			return
+		}
+		collector.names[name] = topic
+		v = &discoveredValue{
+			desc: prometheus.NewDesc(name, fmt.Sprintf("Value published on %s", topic), nil, collector.labels),
+		}
+		collector.Values[topic] = v
+	}
+	v.value = value
+}
+
+// sysTopicMetricName derives a metric name from a $SYS topic, such as
+// mosquitto_sys_broker_retained_messages_count for '$SYS/broker/retained
+// messages/count'. Levels are joined by underscores and every run of
+// characters not allowed in a metric name becomes an underscore.
+func sysTopicMetricName(topic string) string {
+	name := strings.Join(topicLevels(strings.TrimPrefix(topic, "$SYS/")), "_")
+	name = strings.Trim(invalidMetricNameRegexp.ReplaceAllString(name, "_"), "_")
+	return "mosquitto_sys_" + strings.ToLower(name)
+}
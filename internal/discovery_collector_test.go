@@ -0,0 +1,68 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/qaoru/mosquitto_exporter/internal/mqtttest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSysTopicMetricName(t *testing.T) {
+	assert.Equal(t, "mosquitto_sys_broker_retained_messages_count", sysTopicMetricName("$SYS/broker/retained messages/count"))
+	assert.Equal(t, "mosquitto_sys_broker_packets_received_1min", sysTopicMetricName("$SYS/broker/packets/received/1min"))
+	assert.Equal(t, "mosquitto_sys_broker_plugin_dyn_sec_count", sysTopicMetricName("$SYS/broker/plugin/Dyn-Sec/count"))
+}
+
+func TestDiscoveryCollector_Handler(t *testing.T) {
+	labels := prometheus.Labels{"broker": "test-broker"}
+	collector := NewDiscoveryCollector(labels, DiscoveryOptions{}, []SysMapping{{Topic: "$SYS/broker/memory/used", Metric: "mosquitto_memory_bytes"}})
+	client := newFakeClient("exporter")
+	collector.Subscribe(client)
+	registry := registryWith(t, collector)
+
+	// Topics of the dedicated collectors are left to them
+	for topic, payload := range mqtttest.SysTree(100) {
+		client.publish(topic, payload)
+	}
+	client.publish("$SYS/broker/timestamp", "Mon Jan 1 00:00:00 UTC 2024")
+	// So are the mapped ones
+	client.publish("$SYS/broker/memory/used", "2048")
+	assert.Empty(t, collector.Values)
+
+	client.publish("$SYS/broker/packets/received", "12")
+	client.publish("$SYS/broker/packets received", "13")
+	client.publish("$SYS/broker/memory/ratio", " 0.25\n")
+	client.publish("$SYS/broker/packets/received", "14")
+
+	assert.Equal(t, []float64{14}, gatherValues(t, registry, "mosquitto_sys_broker_packets_received"))
+	assert.Equal(t, []float64{0.25}, gatherValues(t, registry, "mosquitto_sys_broker_memory_ratio"))
+	// Same metric name as another topic
+	assert.Len(t, collector.Values, 2)
+
+	collector.brokerRestarted()
+	assert.Empty(t, gatherValues(t, registry, "mosquitto_sys_broker_packets_received"))
+}
+
+func TestDiscoveryCollector_Options(t *testing.T) {
+	labels := prometheus.Labels{"broker": "test-broker"}
+	collector := NewDiscoveryCollector(labels, DiscoveryOptions{
+		RegexpFilter: RegexpFilter{Allow: `^\$SYS/broker/packets/`, Deny: `/sent$`},
+		MaxSeries:    2,
+	}, nil)
+	client := newFakeClient("exporter")
+	collector.Subscribe(client)
+
+	client.publish("$SYS/broker/memory/ratio", "0.25")
+	client.publish("$SYS/broker/packets/sent", "1")
+	client.publish("$SYS/broker/packets/received", "2")
+	client.publish("$SYS/broker/packets/dropped", "3")
+	client.publish("$SYS/broker/packets/queued", "4")
+
+	assert.Len(t, collector.Values, 2)
+	assert.Contains(t, collector.Values, "$SYS/broker/packets/received")
+	assert.Contains(t, collector.Values, "$SYS/broker/packets/dropped")
+
+	assert.Error(t, DiscoveryOptions{RegexpFilter: RegexpFilter{Allow: "("}}.validate())
+	assert.Error(t, DiscoveryOptions{MaxSeries: -1}.validate())
+}
@@ -0,0 +1,185 @@
+package internal
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const dynsecTopic = "$CONTROL/dynamic-security/v1"
+
+// DynsecOptions configures the dynamic security collector.
+type DynsecOptions struct {
+	// Interval is how often the clients, groups and roles are listed, one
+	// minute by default.
+	Interval time.Duration `yaml:"interval"`
+}
+
+func (o DynsecOptions) validate() error {
+	if o.Interval < 0 {
+		return errNegativeDuration("dynsec.interval")
+	}
+	return nil
+}
+
+type dynsecClient struct {
+	Username string `json:"username"`
+	Disabled bool   `json:"disabled"`
+}
+
+type dynsecRole struct {
+	Rolename string            `json:"rolename"`
+	ACLs     []json.RawMessage `json:"acls"`
+}
+
+type dynsecListData struct {
+	TotalCount int               `json:"totalCount"`
+	Clients    []json.RawMessage `json:"clients"`
+	Groups     []json.RawMessage `json:"groups"`
+	Roles      []json.RawMessage `json:"roles"`
+}
+
+// DynsecCollector lists the clients, groups and roles of the dynamic security
+// plugin through its control API.
+type DynsecCollector struct {
+	mu           sync.RWMutex
+	Metrics      map[string]float64
+	RoleACLs     map[string]float64
+	descriptions map[string]metric
+	// errors counts the errors returned by the plugin per command and error
+	errors *prometheus.CounterVec
+	poller *controlPoller
+}
+
+func init() {
+	registerCollector("dynsec", false, "Enable the dynamic security plugin collector.", func(labels prometheus.Labels, options CollectorOptions) Collector {
+		return NewDynsecCollector(labels, options.Dynsec)
+	})
+}
+
+func NewDynsecCollector(labels prometheus.Labels, options DynsecOptions) *DynsecCollector {
+	collector := &DynsecCollector{
+		mu:       sync.RWMutex{},
+		Metrics:  make(map[string]float64, 4),
+		RoleACLs: make(map[string]float64, 8),
+		descriptions: map[string]metric{
+			"clients": {
+				desc:      prometheus.NewDesc("mosquitto_dynsec_clients", "Number of clients defined in the dynamic security plugin", nil, labels),
+				valueType: prometheus.GaugeValue,
+			},
+			"disabled_clients": {
+				desc:      prometheus.NewDesc("mosquitto_dynsec_disabled_clients", "Number of disabled clients in the dynamic security plugin", nil, labels),
+				valueType: prometheus.GaugeValue,
+			},
+			"groups": {
+				desc:      prometheus.NewDesc("mosquitto_dynsec_groups", "Number of groups defined in the dynamic security plugin", nil, labels),
+				valueType: prometheus.GaugeValue,
+			},
+			"roles": {
+				desc:      prometheus.NewDesc("mosquitto_dynsec_roles", "Number of roles defined in the dynamic security plugin", nil, labels),
+				valueType: prometheus.GaugeValue,
+			},
+			"role_acls": {
+				desc:      prometheus.NewDesc("mosquitto_dynsec_role_acls", "Number of ACLs of a dynamic security role", []string{"role"}, labels),
+				valueType: prometheus.GaugeValue,
+			},
+		},
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "mosquitto_dynsec_command_errors_total",
+			Help:        "Total number of dynamic security command errors",
+			ConstLabels: labels,
+		}, []string{"command", "error"}),
+	}
+	collector.poller = newControlPoller(dynsecTopic, options.Interval, []controlCommand{
+		{Command: "listClients", Verbose: true},
+		{Command: "listGroups"},
+		{Command: "listRoles", Verbose: true},
+	}, collector.handleResponse, func(command string, err string) {
+		collector.errors.WithLabelValues(command, err).Inc()
+	})
+	return collector
+}
+
+func (collector *DynsecCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range collector.descriptions {
+		ch <- desc.desc
+	}
+	collector.errors.Describe(ch)
+}
+
+func (collector *DynsecCollector) Collect(ch chan<- prometheus.Metric) {
+	collector.errors.Collect(ch)
+
+	collector.mu.RLock()
+	defer collector.mu.RUnlock()
+
+	// Values are only exported once the plugin answered
+	for k, v := range collector.Metrics {
+		ch <- prometheus.MustNewConstMetric(collector.descriptions[k].desc, collector.descriptions[k].valueType, v)
+	}
+	roleACLs := collector.descriptions["role_acls"]
+	for role, v := range collector.RoleACLs {
+		ch <- prometheus.MustNewConstMetric(roleACLs.desc, roleACLs.valueType, v, role)
+	}
+}
+
+func (collector *DynsecCollector) Subscribe(client Client) {
+	collector.poller.subscribe(client)
+}
+
+// Stop ends the dynamic security commands.
+func (collector *DynsecCollector) Stop() {
+	collector.poller.stop()
+}
+
+func (collector *DynsecCollector) handleResponse(response controlResponse) {
+	var data dynsecListData
+	if err := json.Unmarshal(response.Data, &data); err != nil {
+		log.Printf("Invalid %s response: %v", response.Command, err)
+		collector.errors.WithLabelValues(response.Command, "invalid response").Inc()
+		return
+	}
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	switch response.Command {
+	case "listClients":
+		disabled := 0
+		for _, raw := range data.Clients {
+			var client dynsecClient
+			if json.Unmarshal(raw, &client) == nil && client.Disabled {
+				disabled++
+			}
+		}
+		collector.Metrics["clients"] = float64(listCount(data.TotalCount, data.Clients))
+		collector.Metrics["disabled_clients"] = float64(disabled)
+	case "listGroups":
+		collector.Metrics["groups"] = float64(listCount(data.TotalCount, data.Groups))
+	case "listRoles":
+		collector.Metrics["roles"] = float64(listCount(data.TotalCount, data.Roles))
+		roleACLs := make(map[string]float64, len(data.Roles))
+		for _, raw := range data.Roles {
+			var role dynsecRole
+			if err := json.Unmarshal(raw, &role); err != nil {
+				// Non verbose listings only contain the role names
+				continue
+			}
+			roleACLs[role.Rolename] = float64(len(role.ACLs))
+		}
+		collector.RoleACLs = roleACLs
+	default:
+		log.Printf("Unexpected dynamic security response: %s", response.Command)
+	}
+}
+
+// listCount prefers the total count reported by the plugin, which older
+// versions do not send.
+func listCount(totalCount int, items []json.RawMessage) int {
+	if totalCount > 0 {
+		return totalCount
+	}
+	return len(items)
+}
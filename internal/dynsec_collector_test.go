@@ -0,0 +1,77 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDynsecCollector(t *testing.T) {
+	labels := prometheus.Labels{"broker": "test-broker"}
+	collector := NewDynsecCollector(labels, DynsecOptions{})
+
+	assert.NotNil(t, collector)
+	assert.NotNil(t, collector.Metrics)
+	assert.Equal(t, 5, len(collector.descriptions))
+	assert.Len(t, collector.poller.commands, 3)
+}
+
+func TestDynsecCollector_Responses(t *testing.T) {
+	labels := prometheus.Labels{"broker": "test-broker"}
+	collector := NewDynsecCollector(labels, DynsecOptions{})
+	client := newFakeClient("exporter")
+	collector.Subscribe(client)
+	defer collector.Stop()
+	registry := registryWith(t, collector)
+
+	// Nothing is exported before the plugin answered
+	count, err := testutil.GatherAndCount(registry)
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	client.publish("$CONTROL/dynamic-security/v1/response", `{"responses":[
+			{"command":"listClients","data":{"totalCount":3,"clients":[
+				{"username":"admin"},
+				{"username":"sensor","disabled":true},
+				{"username":"old","disabled":true}
+			]}},
+			{"command":"listGroups","data":{"totalCount":0,"groups":["devices","admins"]}},
+			{"command":"listRoles","data":{"totalCount":2,"roles":[
+				{"rolename":"admin","acls":[{"acltype":"publishClientSend","topic":"#","allow":true}]},
+				{"rolename":"device","acls":[]}
+			]}}
+		]}`)
+
+	assert.Equal(t, map[string]float64{
+		"clients":          3,
+		"disabled_clients": 2,
+		"groups":           2,
+		"roles":            2,
+	}, collector.Metrics)
+	assert.Equal(t, map[string]float64{"admin": 1, "device": 0}, collector.RoleACLs)
+
+	count, err = testutil.GatherAndCount(registry, "mosquitto_dynsec_role_acls")
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
+
+func TestDynsecCollector_Errors(t *testing.T) {
+	labels := prometheus.Labels{"broker": "test-broker"}
+	collector := NewDynsecCollector(labels, DynsecOptions{})
+	client := newFakeClient("exporter")
+	collector.Subscribe(client)
+	defer collector.Stop()
+	registry := registryWith(t, collector)
+
+	client.publish("$CONTROL/dynamic-security/v1/response", `{"responses":[{"command":"listRoles","error":"Permission denied"}]}`)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(collector.errors.WithLabelValues("listRoles", "Permission denied")))
+	assert.Equal(t, []float64{1}, gatherValues(t, registry, "mosquitto_dynsec_command_errors_total"))
+	assert.Empty(t, collector.Metrics)
+
+	assert.Error(t, DynsecOptions{Interval: -time.Second}.validate())
+}
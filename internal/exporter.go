@@ -1,6 +1,14 @@
 package internal
 
 import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -8,3 +16,125 @@ type metric struct {
 	desc      *prometheus.Desc
 	valueType prometheus.ValueType
 }
+
+// Exporter keeps track of the brokers being scraped and of their
+// registration, so the set of brokers can be changed at runtime.
+type Exporter struct {
+	mu         sync.Mutex
+	registerer prometheus.Registerer
+	brokers    map[string]*Broker
+}
+
+// NewExporter creates an Exporter registering the brokers in registerer.
+func NewExporter(registerer prometheus.Registerer) *Exporter {
+	return &Exporter{
+		registerer: registerer,
+		brokers:    make(map[string]*Broker),
+	}
+}
+
+// ApplyConfig connects to the brokers in configs. Brokers whose configuration
+// did not change keep their connection, the others are reconnected and the
+// brokers missing from configs are disconnected. Nothing is changed if one of
+// the new brokers cannot be created or registered.
+func (e *Exporter) ApplyConfig(configs []BrokerConfig) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	changed := make(map[string]*Broker, len(configs))
+	wanted := make(map[string]bool, len(configs))
+	for _, cfg := range configs {
+		wanted[cfg.Label()] = true
+		if current, ok := e.brokers[cfg.Label()]; ok && reflect.DeepEqual(current.Config(), cfg) {
+			continue
+		}
+		broker, err := NewBroker(cfg)
+		if err != nil {
+			discardBrokers(changed)
+			return fmt.Errorf("broker %s: %w", cfg.Label(), err)
+		}
+		changed[cfg.Label()] = broker
+	}
+
+	// The brokers being replaced describe the same metrics as their
+	// replacements, they are unregistered first and registered back if one
+	// of the new brokers cannot be registered.
+	for label := range changed {
+		if current, ok := e.brokers[label]; ok {
+			e.registerer.Unregister(current)
+		}
+	}
+	registered := make([]*Broker, 0, len(changed))
+	for label, broker := range changed {
+		if err := e.registerer.Register(broker); err != nil {
+			for _, broker := range registered {
+				e.registerer.Unregister(broker)
+			}
+			for label := range changed {
+				if current, ok := e.brokers[label]; ok {
+					if err := e.registerer.Register(current); err != nil {
+						log.Printf("Failed to register broker %s again: %v", label, err)
+					}
+				}
+			}
+			discardBrokers(changed)
+			return fmt.Errorf("broker %s: %w", label, err)
+		}
+		registered = append(registered, broker)
+	}
+
+	for label, broker := range e.brokers {
+		_, replaced := changed[label]
+		if replaced || !wanted[label] {
+			log.Printf("Disconnecting from broker %s", label)
+			if !replaced {
+				e.registerer.Unregister(broker)
+			}
+			broker.Disconnect()
+			delete(e.brokers, label)
+		}
+	}
+	for label, broker := range changed {
+		broker.Connect()
+		e.brokers[label] = broker
+	}
+	return nil
+}
+
+// discardBrokers releases brokers that were created but never connected.
+func discardBrokers(brokers map[string]*Broker) {
+	for _, broker := range brokers {
+		broker.Disconnect()
+	}
+}
+
+// ServeClients lists the clients tracked on every broker as JSON.
+func (e *Exporter) ServeClients(w http.ResponseWriter, r *http.Request) {
+	e.mu.Lock()
+	labels := make([]string, 0, len(e.brokers))
+	for label := range e.brokers {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	clients := make([]ClientInfo, 0)
+	for _, label := range labels {
+		clients = append(clients, e.brokers[label].Clients()...)
+	}
+	e.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(clients); err != nil {
+		log.Printf("Failed to write clients list: %v", err)
+	}
+}
+
+// Close disconnects from all the brokers.
+func (e *Exporter) Close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for label, broker := range e.brokers {
+		e.registerer.Unregister(broker)
+		broker.Disconnect()
+		delete(e.brokers, label)
+	}
+}
@@ -0,0 +1,83 @@
+package internal
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExporter_ApplyConfig(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	exporter := NewExporter(registry)
+	defer exporter.Close()
+
+	edge1 := BrokerConfig{Name: "edge-1", URL: "tcp://" + closedAddress(t)}
+	edge2 := BrokerConfig{Name: "edge-2", URL: "tcp://" + closedAddress(t)}
+	require.NoError(t, exporter.ApplyConfig([]BrokerConfig{edge1, edge2}))
+	count, err := testutil.GatherAndCount(registry, "mosquitto_up")
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	first := exporter.brokers["edge-1"]
+	second := exporter.brokers["edge-2"]
+
+	// Only the broker whose settings changed is replaced
+	edge2.Username = "exporter"
+	require.NoError(t, exporter.ApplyConfig([]BrokerConfig{edge1, edge2}))
+	assert.Same(t, first, exporter.brokers["edge-1"])
+	assert.NotSame(t, second, exporter.brokers["edge-2"])
+
+	// Removed brokers are unregistered
+	require.NoError(t, exporter.ApplyConfig([]BrokerConfig{edge1}))
+	count, err = testutil.GatherAndCount(registry, "mosquitto_up")
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	// A broker that cannot be registered leaves the running brokers
+	// registered and connected
+	require.NoError(t, exporter.ApplyConfig([]BrokerConfig{edge1, edge2}))
+	second = exporter.brokers["edge-2"]
+	labelled := edge1
+	labelled.Labels = map[string]string{"zone": "a"}
+	assert.Error(t, exporter.ApplyConfig([]BrokerConfig{labelled, edge2}))
+	assert.Same(t, first, exporter.brokers["edge-1"])
+	assert.Same(t, second, exporter.brokers["edge-2"])
+	count, err = testutil.GatherAndCount(registry, "mosquitto_up")
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	require.NoError(t, exporter.ApplyConfig([]BrokerConfig{edge1}))
+
+	// An invalid configuration leaves the running brokers untouched
+	invalid := BrokerConfig{Name: "edge-3", URL: "tcp://" + closedAddress(t), ModuleConfig: ModuleConfig{Collectors: []string{"unknown"}}}
+	assert.Error(t, exporter.ApplyConfig([]BrokerConfig{invalid}))
+	assert.Same(t, first, exporter.brokers["edge-1"])
+	assert.Len(t, exporter.brokers, 1)
+}
+
+func TestExporter_ServeClients(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	exporter := NewExporter(registry)
+	defer exporter.Close()
+
+	require.NoError(t, exporter.ApplyConfig([]BrokerConfig{
+		{Name: "edge-1", URL: "tcp://" + closedAddress(t), ModuleConfig: ModuleConfig{Collectors: []string{"connections"}}},
+		{Name: "edge-2", URL: "tcp://" + closedAddress(t)},
+	}))
+	tracker := exporter.brokers["edge-1"].collectors[0].(*ConnectionsCollector)
+	feedConnectionLogs(tracker, "1700000000: New client connected from 10.0.0.1:51234 as sensor-1 (p2, c1, k60).")
+
+	recorder := httptest.NewRecorder()
+	exporter.ServeClients(recorder, httptest.NewRequest(http.MethodGet, "/clients", nil))
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+
+	var clients []ClientInfo
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &clients))
+	require.Len(t, clients, 1)
+	assert.Equal(t, "edge-1", clients[0].Broker)
+	assert.Equal(t, "sensor-1", clients[0].ClientID)
+}
@@ -0,0 +1,207 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// fakeMessage implements Message.
+type fakeMessage struct {
+	topic    string
+	payload  []byte
+	qos      byte
+	retained bool
+}
+
+func (m *fakeMessage) Topic() string   { return m.topic }
+func (m *fakeMessage) Payload() []byte { return m.payload }
+func (m *fakeMessage) Retained() bool  { return m.retained }
+func (m *fakeMessage) Qos() byte       { return m.qos }
+
+// fakeBroker delivers the messages published by its clients in memory,
+// including the retained messages on later subscriptions.
+type fakeBroker struct {
+	mu        sync.Mutex
+	clients   []*fakeClient
+	retained  map[string]*fakeMessage
+	published []*fakeMessage
+}
+
+func newFakeBroker() *fakeBroker {
+	return &fakeBroker{retained: make(map[string]*fakeMessage)}
+}
+
+// client returns a new connected client of the broker.
+func (b *fakeBroker) client(clientID string) *fakeClient {
+	client := &fakeClient{broker: b, clientID: clientID, connected: true, subscribeErrs: make(map[string]error)}
+	b.mu.Lock()
+	b.clients = append(b.clients, client)
+	b.mu.Unlock()
+	return client
+}
+
+func (b *fakeBroker) publish(message *fakeMessage) {
+	b.mu.Lock()
+	b.published = append(b.published, message)
+	if message.retained && len(message.payload) == 0 {
+		delete(b.retained, message.topic)
+	} else if message.retained {
+		b.retained[message.topic] = message
+	}
+	var clients []*fakeClient
+	for _, client := range b.clients {
+		if client.connected {
+			clients = append(clients, client)
+		}
+	}
+	b.mu.Unlock()
+
+	// Only new subscriptions get the retained flag
+	live := *message
+	live.retained = false
+	for _, client := range clients {
+		client.deliver(&live)
+	}
+}
+
+// fakeClient is a connection to a fakeBroker.
+type fakeClient struct {
+	broker   *fakeBroker
+	clientID string
+	router   router
+
+	// Guarded by broker.mu
+	connected bool
+	// subscriptions are the filters subscribed on the broker side, which
+	// like Mosquitto sends a copy of a message for each matching one.
+	subscriptions map[string]bool
+	connectErr    error
+	subscribeErrs map[string]error
+	publishErr    error
+}
+
+// newFakeClient returns a connected client of a new fakeBroker.
+func newFakeClient(clientID string) *fakeClient {
+	return newFakeBroker().client(clientID)
+}
+
+func (c *fakeClient) Connect(ctx context.Context) error {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	if c.connectErr != nil {
+		return c.connectErr
+	}
+	c.connected = true
+	return nil
+}
+
+func (c *fakeClient) Disconnect(time.Duration) {
+	c.broker.mu.Lock()
+	c.connected = false
+	c.subscriptions = nil
+	c.broker.mu.Unlock()
+	c.router.reset()
+}
+
+func (c *fakeClient) Subscribe(filter string, qos byte, handler MessageHandler) error {
+	sub, ok := c.router.add(filter, qos, handler)
+	if !ok {
+		return nil
+	}
+	c.broker.mu.Lock()
+	if err := c.subscribeErrs[filter]; err != nil {
+		c.broker.mu.Unlock()
+		c.router.remove(filter, sub)
+		return err
+	}
+	if c.subscriptions == nil {
+		c.subscriptions = make(map[string]bool)
+	}
+	c.subscriptions[sub.filter] = true
+	var retained []*fakeMessage
+	for topic, message := range c.broker.retained {
+		if topicMatches(sub.filter, topic) {
+			retained = append(retained, message)
+		}
+	}
+	c.broker.mu.Unlock()
+
+	for _, message := range retained {
+		c.router.dispatch(c, message)
+	}
+	c.broker.mu.Lock()
+	for _, covered := range sub.unsubscribe() {
+		delete(c.subscriptions, covered)
+	}
+	c.broker.mu.Unlock()
+	return nil
+}
+
+// deliver passes message to the router once per matching subscription.
+func (c *fakeClient) deliver(message *fakeMessage) {
+	c.broker.mu.Lock()
+	copies := 0
+	for filter := range c.subscriptions {
+		if topicMatches(filter, message.topic) {
+			copies++
+		}
+	}
+	c.broker.mu.Unlock()
+	for range copies {
+		c.router.dispatch(c, message)
+	}
+}
+
+func (c *fakeClient) Publish(ctx context.Context, topic string, qos byte, retained bool, payload []byte) error {
+	c.broker.mu.Lock()
+	err := c.publishErr
+	if err == nil && !c.connected {
+		err = errors.New("not connected")
+	}
+	c.broker.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	c.broker.publish(&fakeMessage{topic: topic, payload: payload, qos: qos, retained: retained})
+	return nil
+}
+
+func (c *fakeClient) IsConnectionOpen() bool {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	return c.connected
+}
+
+func (c *fakeClient) ClientID() string {
+	return c.clientID
+}
+
+// publish delivers payload on topic as if another client had published it.
+func (c *fakeClient) publish(topic string, payload string) {
+	c.broker.publish(&fakeMessage{topic: topic, payload: []byte(payload)})
+}
+
+// publishRetained stores a retained message and delivers it to the current
+// subscriptions as the broker does on subscribe.
+func (c *fakeClient) publishRetained(topic string, payload string) {
+	message := &fakeMessage{topic: topic, payload: []byte(payload), retained: true}
+	c.broker.mu.Lock()
+	c.broker.retained[topic] = message
+	c.broker.mu.Unlock()
+	c.deliver(message)
+}
+
+// publishedOn returns the payloads published on topic.
+func (c *fakeClient) publishedOn(topic string) []string {
+	c.broker.mu.Lock()
+	defer c.broker.mu.Unlock()
+	var payloads []string
+	for _, message := range c.broker.published {
+		if message.topic == topic {
+			payloads = append(payloads, string(message.payload))
+		}
+	}
+	return payloads
+}
@@ -0,0 +1,256 @@
+package internal
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/qaoru/mosquitto_exporter/internal/mqtttest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The tests of this file run the exporter against the in-process server of
+// mqtttest.
+
+// eventually waits for condition, which may take a reconnection.
+func eventually(t *testing.T, condition func() bool, msgAndArgs ...interface{}) {
+	t.Helper()
+	require.Eventually(t, condition, 10*time.Second, 20*time.Millisecond, msgAndArgs...)
+}
+
+// connectBroker connects a broker to server and waits until it is up.
+func connectBroker(t *testing.T, server *mqtttest.Server, module ModuleConfig) *prometheus.Registry {
+	t.Helper()
+	broker, err := NewBroker(BrokerConfig{URL: server.URL(), ModuleConfig: module})
+	require.NoError(t, err)
+	registry := registryWith(t, broker)
+	broker.Connect()
+	t.Cleanup(broker.Disconnect)
+	eventually(t, func() bool {
+		return slices.Equal(gatherValues(t, registry, "mosquitto_up"), []float64{1})
+	}, "broker not up")
+	return registry
+}
+
+// subscribedCount returns how many times filter was subscribed.
+func subscribedCount(server *mqtttest.Server, filter string) int {
+	count := 0
+	for _, subscribed := range server.Subscriptions() {
+		if subscribed == filter {
+			count++
+		}
+	}
+	return count
+}
+
+func TestIntegration_Up(t *testing.T) {
+	server := mqtttest.NewServer(t)
+	server.PublishSys(mqtttest.SysTree(100))
+	registry := connectBroker(t, server, ModuleConfig{Collectors: []string{"clients"}})
+	eventually(t, func() bool {
+		return slices.Equal(gatherValues(t, registry, "mosquitto_connected_clients_count"), []float64{5})
+	})
+
+	// The broker goes away and refuses the exporter when it comes back
+	server.RefuseConnections(5)
+	server.DropConnections()
+	eventually(t, func() bool {
+		return slices.Equal(gatherValues(t, registry, "mosquitto_up"), []float64{0})
+	}, "broker still up")
+
+	server.RefuseConnections(0)
+	eventually(t, func() bool {
+		return slices.Equal(gatherValues(t, registry, "mosquitto_up"), []float64{1})
+	}, "broker not up again")
+}
+
+func TestIntegration_Resubscribe(t *testing.T) {
+	server := mqtttest.NewServer(t)
+	server.PublishSys(mqtttest.SysTree(100))
+	registry := connectBroker(t, server, ModuleConfig{Collectors: []string{"clients"}})
+	eventually(t, func() bool { return subscribedCount(server, "$SYS/broker/clients/#") == 1 })
+
+	server.DropConnections()
+	eventually(t, func() bool { return subscribedCount(server, "$SYS/broker/clients/#") == 2 }, "not subscribed again")
+	assert.Equal(t, 2, subscribedCount(server, mqtttest.UptimeTopic))
+	assert.Equal(t, 2, server.Connects())
+
+	// Values published after the reconnection are still received, once
+	server.Publish("$SYS/broker/clients/connected", "9", true)
+	eventually(t, func() bool {
+		return slices.Equal(gatherValues(t, registry, "mosquitto_connected_clients_count"), []float64{9})
+	})
+}
+
+func TestIntegration_RejectedSubscription(t *testing.T) {
+	server := mqtttest.NewServer(t)
+	server.PublishSys(mqtttest.SysTree(100))
+	server.RejectSubscriptions("$SYS/broker/load/#")
+
+	registry := connectBroker(t, server, ModuleConfig{Collectors: []string{"load", "clients"}})
+	eventually(t, func() bool { return subscribedCount(server, "$SYS/broker/clients/#") == 1 })
+
+	assert.Equal(t, []float64{1}, gatherValues(t, registry, "mosquitto_subscription_errors_total"))
+	assert.Zero(t, subscribedCount(server, "$SYS/broker/load/#"))
+	// The other collectors are not affected
+	eventually(t, func() bool {
+		return slices.Equal(gatherValues(t, registry, "mosquitto_connected_clients_count"), []float64{5})
+	})
+	// Values that were never received are not exported as zeros
+	assert.Empty(t, gatherValues(t, registry, "mosquitto_connections_load1"))
+}
+
+func TestIntegration_Restart(t *testing.T) {
+	server := mqtttest.NewServer(t)
+	server.PublishSys(mqtttest.SysTree(100))
+	registry := connectBroker(t, server, ModuleConfig{Collectors: []string{"messages"}})
+	eventually(t, func() bool {
+		return slices.Equal(gatherValues(t, registry, "mosquitto_sent_messages_count"), []float64{2400})
+	})
+
+	// The new run of the broker did not publish the sent messages yet
+	tree := mqtttest.SysTree(5)
+	tree["$SYS/broker/messages/received"] = "3"
+	tree["$SYS/broker/messages/sent"] = ""
+	server.PublishSys(tree)
+	server.DropConnections()
+	eventually(t, func() bool {
+		return slices.Equal(gatherValues(t, registry, "mosquitto_broker_restarts_total"), []float64{1})
+	}, "restart not detected")
+	eventually(t, func() bool {
+		return slices.Equal(gatherValues(t, registry, "mosquitto_received_messages_count"), []float64{3})
+	})
+	assert.Empty(t, gatherValues(t, registry, "mosquitto_sent_messages_count"))
+}
+
+func TestIntegration_Collectors(t *testing.T) {
+	server := mqtttest.NewServer(t)
+	server.HandlePublish(dynsecTopic, func(string, []byte) {
+		server.Publish(dynsecTopic+"/response", `{"responses":[
+			{"command":"listClients","data":{"totalCount":2,"clients":[{"username":"admin"},{"username":"old","disabled":true}]}},
+			{"command":"listGroups","data":{"totalCount":1,"groups":["devices"]}},
+			{"command":"listRoles","data":{"totalCount":1,"roles":[{"rolename":"admin","acls":[{"acltype":"subscribePattern","topic":"#","allow":true}]}]}}
+		]}`, false)
+	})
+	server.HandlePublish(brokerControlTopic, func(string, []byte) {
+		server.Publish(brokerControlTopic+"/response", `{"responses":[
+			{"command":"listListeners","data":{"listeners":[{"port":1883,"protocol":"mqtt","tls":false}]}},
+			{"command":"listPlugins","data":{"plugins":[{"name":"dynamic-security","version":"2.0.18"}]}}
+		]}`, false)
+	})
+	server.ReplaySys(50*time.Millisecond, mqtttest.SysTree(100))
+
+	var collectors []string
+	for _, collector := range Collectors() {
+		collectors = append(collectors, collector.Name)
+	}
+	registry := connectBroker(t, server, ModuleConfig{
+		ClientID:   "exporter",
+		Collectors: collectors,
+		Options: &CollectorOptions{
+			Topics:   TopicsOptions{Filters: []string{"devices/#"}},
+			Liveness: LivenessOptions{Watches: []LivenessWatch{{Filter: "devices/+/heartbeat", Threshold: time.Minute}}},
+			Payload: PayloadOptions{Rules: []PayloadRule{{
+				Filter:   "devices/+/telemetry",
+				Selector: "$.temperature",
+				Metric:   "device_temperature_celsius",
+				Labels:   map[string]string{"device": "topic:1"},
+			}}},
+			Sys: SysOptions{Mappings: []SysMapping{{
+				Topic:  "$SYS/broker/memory/+",
+				Metric: "mosquitto_memory_bytes",
+				Parser: "integer",
+				Labels: map[string]string{"kind": "topic:1"},
+			}}},
+		},
+	})
+	// The topics collector subscribes after the ones reading the messages
+	// published below, replacing their subscriptions
+	eventually(t, func() bool {
+		subscribed := server.Subscribed()
+		return slices.Contains(subscribed, "devices/#") && !slices.Contains(subscribed, "devices/+/telemetry")
+	})
+	server.Publish("$SYS/broker/log/N", "1700000000: New client connected from 10.0.0.1:51234 as sensor-1 (p2, c1, k60).", false)
+	server.Publish("devices/d1/heartbeat", "1", false)
+	server.Publish("$SYS/broker/packets/received", "7", true)
+	server.Publish("$SYS/broker/memory/used", "2048 bytes", true)
+	server.Publish("devices/d1/telemetry", `{"temperature":21.5}`, false)
+
+	expected := map[string][]float64{
+		"mosquitto_up":                             {1},
+		"mosquitto_sys_data_received":              {1},
+		"mosquitto_uptime_seconds":                 {100},
+		"mosquitto_subscriptions_total":            {12},
+		"mosquitto_connected_clients_count":        {5},
+		"mosquitto_received_messages_count":        {1200},
+		"mosquitto_stored_messages_bytes":          {8192},
+		"mosquitto_connections_load1":              {0.5},
+		"mosquitto_heap_current_bytes":             {52496},
+		"mosquitto_bytes_received_total":           {123456},
+		"mosquitto_retained_messages":              {42},
+		"mosquitto_bridge_up":                      {1},
+		"mosquitto_log_events_total":               {1},
+		"mosquitto_tracked_clients":                {1},
+		"mosquitto_dynsec_disabled_clients":        {1},
+		"mosquitto_dynsec_role_acls":               {1},
+		"mosquitto_control_api_supported":          {1},
+		"mosquitto_listener_info":                  {1},
+		"mosquitto_probe_success":                  {1, 1, 1},
+		"mosquitto_retained_probe_success":         {1},
+		"mosquitto_topic_messages_total":           {1, 1},
+		"mosquitto_topic_stale":                    {0},
+		"device_temperature_celsius":               {21.5},
+		"mosquitto_sys_broker_packets_received":    {7},
+		"mosquitto_memory_bytes":                   {2048},
+		"mosquitto_shared_subscriptions_total":     {2},
+		"mosquitto_publish_messages_dropped_total": {4},
+	}
+	for name, values := range expected {
+		eventually(t, func() bool {
+			return slices.Equal(gatherValues(t, registry, name), values)
+		}, "%s: got %v, expected %v", name, gatherValues(t, registry, name), values)
+	}
+	assert.Contains(t, server.Clients(), "exporter")
+}
+
+func TestIntegration_Probe(t *testing.T) {
+	server := mqtttest.NewServer(t)
+	server.ReplaySys(50*time.Millisecond, mqtttest.SysTree(100), mqtttest.SysTree(101), mqtttest.SysTree(102))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	registry := prometheus.NewRegistry()
+	require.NoError(t, Probe(ctx, BrokerConfig{URL: server.URL(), ModuleConfig: ModuleConfig{Collectors: []string{"clients"}}}, registry))
+
+	assert.Equal(t, []float64{1}, gatherValues(t, registry, "mosquitto_up"))
+	assert.Equal(t, []float64{1}, gatherValues(t, registry, "mosquitto_probe_sys_complete"))
+	assert.Equal(t, []float64{5}, gatherValues(t, registry, "mosquitto_connected_clients_count"))
+	// The probe disconnected
+	eventually(t, func() bool { return len(server.Clients()) == 0 })
+
+	// Refused connections are reported through mosquitto_up
+	server.RefuseConnections(5)
+	registry = prometheus.NewRegistry()
+	require.NoError(t, Probe(ctx, BrokerConfig{URL: server.URL()}, registry))
+	assert.Equal(t, []float64{0}, gatherValues(t, registry, "mosquitto_up"))
+}
+
+func TestIntegration_ProbePersistence(t *testing.T) {
+	server := mqtttest.NewServer(t)
+	server.ReplaySys(50*time.Millisecond, mqtttest.SysTree(100), mqtttest.SysTree(101), mqtttest.SysTree(102))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	registry := prometheus.NewRegistry()
+	require.NoError(t, Probe(ctx, BrokerConfig{URL: server.URL(), ModuleConfig: ModuleConfig{Collectors: []string{"persistence"}}}, registry))
+
+	// The topic does not depend on the random client ID of the probe, and
+	// no retained message is left behind
+	topic := "mosquitto_exporter/retained/mosquitto-exporter/probe"
+	assert.Contains(t, server.Subscriptions(), topic)
+	_, ok := server.Retained(topic)
+	assert.False(t, ok)
+}
@@ -0,0 +1,173 @@
+package internal
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// LivenessWatch is a topic filter on which messages are expected at least
+// every Threshold.
+type LivenessWatch struct {
+	Filter    string        `yaml:"filter"`
+	Threshold time.Duration `yaml:"threshold"`
+}
+
+// ParseLivenessWatch parses a watch given as 'FILTER=THRESHOLD', such as
+// 'plant/+/heartbeat=5m'.
+func ParseLivenessWatch(value string) (LivenessWatch, error) {
+	i := strings.LastIndex(value, "=")
+	if i < 0 {
+		return LivenessWatch{}, fmt.Errorf("invalid watch %q, expected FILTER=THRESHOLD", value)
+	}
+	threshold, err := time.ParseDuration(value[i+1:])
+	if err != nil {
+		return LivenessWatch{}, fmt.Errorf("invalid watch %q: %w", value, err)
+	}
+	return LivenessWatch{Filter: value[:i], Threshold: threshold}, nil
+}
+
+// LivenessOptions configures the liveness collector.
+type LivenessOptions struct {
+	Watches []LivenessWatch `yaml:"watches"`
+	// MaxTopics caps the number of topics tracked individually, the
+	// additional topics are tracked under the filter they match.
+	MaxTopics int `yaml:"max_topics"`
+}
+
+func (o LivenessOptions) validate() error {
+	for _, watch := range o.Watches {
+		if err := validTopicFilter(watch.Filter); err != nil {
+			return fmt.Errorf("liveness: %w", err)
+		}
+		if watch.Threshold <= 0 {
+			return fmt.Errorf("liveness: threshold of %q must be positive", watch.Filter)
+		}
+	}
+	if o.MaxTopics < 0 {
+		return fmt.Errorf("liveness.max_topics must not be negative")
+	}
+	return nil
+}
+
+type livenessTopic struct {
+	watch    int
+	lastSeen time.Time
+}
+
+// LivenessCollector tracks the time of the last message received on the
+// topics matching the watched filters, and whether it is older than the
+// threshold of the filter.
+type LivenessCollector struct {
+	mu           sync.RWMutex
+	Topics       map[string]*livenessTopic
+	options      LivenessOptions
+	filters      []string
+	started      time.Time
+	descriptions map[string]metric
+	now          func() time.Time
+}
+
+func init() {
+	registerCollector("liveness", false, "Enable the topic liveness collector.", func(labels prometheus.Labels, options CollectorOptions) Collector {
+		return NewLivenessCollector(labels, options.Liveness)
+	})
+}
+
+func NewLivenessCollector(labels prometheus.Labels, options LivenessOptions) *LivenessCollector {
+	filters := make([]string, 0, len(options.Watches))
+	for _, watch := range options.Watches {
+		filters = append(filters, watch.Filter)
+	}
+	return &LivenessCollector{
+		mu:      sync.RWMutex{},
+		Topics:  make(map[string]*livenessTopic, 16),
+		options: options,
+		filters: filters,
+		started: time.Now(),
+		descriptions: map[string]metric{
+			"timestamp": {
+				desc:      prometheus.NewDesc("mosquitto_topic_last_message_timestamp_seconds", "Time the last message was received on a watched topic", []string{"topic"}, labels),
+				valueType: prometheus.GaugeValue,
+			},
+			"stale": {
+				desc:      prometheus.NewDesc("mosquitto_topic_stale", "Whether no message was received on a watched topic within its threshold", []string{"topic"}, labels),
+				valueType: prometheus.GaugeValue,
+			},
+		},
+		now: time.Now,
+	}
+}
+
+func (collector *LivenessCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range collector.descriptions {
+		ch <- desc.desc
+	}
+}
+
+func (collector *LivenessCollector) Collect(ch chan<- prometheus.Metric) {
+	collector.mu.RLock()
+	defer collector.mu.RUnlock()
+
+	timestamp := collector.descriptions["timestamp"]
+	stale := collector.descriptions["stale"]
+	now := collector.now()
+	watched := make([]bool, len(collector.options.Watches))
+	emitted := make(map[string]bool, len(collector.Topics))
+	for topic, state := range collector.Topics {
+		watched[state.watch] = true
+		emitted[topic] = true
+		threshold := collector.options.Watches[state.watch].Threshold
+		ch <- prometheus.MustNewConstMetric(timestamp.desc, timestamp.valueType, float64(state.lastSeen.UnixNano())/1e9, topic)
+		ch <- prometheus.MustNewConstMetric(stale.desc, stale.valueType, boolToFloat(now.Sub(state.lastSeen) > threshold), topic)
+	}
+	// Filters without any message yet are stale once their threshold passed
+	// since the exporter started. A filter without wildcards may also be a
+	// topic tracked by an earlier watch, its series is already exported.
+	for i, watch := range collector.options.Watches {
+		if watched[i] || emitted[watch.Filter] {
+			continue
+		}
+		emitted[watch.Filter] = true
+		ch <- prometheus.MustNewConstMetric(stale.desc, stale.valueType, boolToFloat(now.Sub(collector.started) > watch.Threshold), watch.Filter)
+	}
+}
+
+func (collector *LivenessCollector) Subscribe(client Client) {
+	for i, filter := range collector.filters {
+		if err := client.Subscribe(filter, 0, collector.watchHandler(i)); err != nil {
+			log.Printf("Failed to subscribe to %s: %v", filter, err)
+		}
+	}
+}
+
+// watchHandler returns the handler of the i-th watch. A topic belongs to the
+// first watch matching it.
+func (collector *LivenessCollector) watchHandler(i int) MessageHandler {
+	return func(client Client, message Message) {
+		// Retained messages were published at an unknown time
+		if message.Retained() || firstMatchingFilter(collector.filters, message.Topic()) != i {
+			return
+		}
+		topic := message.Topic()
+
+		collector.mu.Lock()
+		defer collector.mu.Unlock()
+		state, ok := collector.Topics[topic]
+		if !ok {
+			if collector.options.MaxTopics > 0 && len(collector.Topics) >= collector.options.MaxTopics {
+				topic = collector.filters[i]
+				state = collector.Topics[topic]
+			}
+			if state == nil {
+				state = &livenessTopic{watch: i}
+				collector.Topics[topic] = state
+			}
+		}
+		state.lastSeen = collector.now()
+	}
+}
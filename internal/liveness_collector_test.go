@@ -0,0 +1,110 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestParseLivenessWatch(t *testing.T) {
+	watch, err := ParseLivenessWatch("plant/+/heartbeat=5m")
+	require.NoError(t, err)
+	assert.Equal(t, LivenessWatch{Filter: "plant/+/heartbeat", Threshold: 5 * time.Minute}, watch)
+
+	_, err = ParseLivenessWatch("plant/+/heartbeat")
+	assert.Error(t, err)
+	_, err = ParseLivenessWatch("plant/+/heartbeat=soon")
+	assert.Error(t, err)
+}
+
+func TestLivenessOptions_YAML(t *testing.T) {
+	var options LivenessOptions
+	require.NoError(t, yaml.Unmarshal([]byte("watches:\n  - filter: plant/+/heartbeat\n    threshold: 5m\n"), &options))
+	assert.Equal(t, []LivenessWatch{{Filter: "plant/+/heartbeat", Threshold: 5 * time.Minute}}, options.Watches)
+	assert.NoError(t, options.validate())
+
+	assert.Error(t, LivenessOptions{Watches: []LivenessWatch{{Filter: "plant/+/heartbeat"}}}.validate())
+	assert.Error(t, LivenessOptions{Watches: []LivenessWatch{{Filter: "plant/#/x", Threshold: time.Minute}}}.validate())
+}
+
+func TestLivenessCollector_WatchHandler(t *testing.T) {
+	labels := prometheus.Labels{"broker": "test-broker"}
+	collector := NewLivenessCollector(labels, LivenessOptions{
+		Watches: []LivenessWatch{
+			{Filter: "plant/+/heartbeat", Threshold: time.Minute},
+			{Filter: "plant/#", Threshold: time.Hour},
+			{Filter: "alarms/panel", Threshold: time.Hour},
+		},
+		MaxTopics: 2,
+	})
+	now := time.Now()
+	collector.started = now
+	collector.now = func() time.Time { return now }
+	registry := registryWith(t, collector)
+	client := newFakeClient("exporter")
+	collector.Subscribe(client)
+
+	// Delivered to both matching filters, only tracked by the first one
+	client.publish("plant/p1/heartbeat", "")
+	client.publish("plant/p2/heartbeat", "")
+	// Retained messages may be old, they are ignored
+	client.publishRetained("alarms/panel", "")
+	// Beyond the limit, tracked under the filter
+	now = now.Add(30 * time.Second)
+	client.publish("plant/p3/heartbeat", "")
+
+	require.Len(t, collector.Topics, 3)
+	assert.Equal(t, 0, collector.Topics["plant/p1/heartbeat"].watch)
+	assert.Contains(t, collector.Topics, "plant/+/heartbeat")
+
+	now = now.Add(45 * time.Second)
+	stale := map[string]float64{}
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() != "mosquitto_topic_stale" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "topic" {
+					stale[label.GetValue()] = m.GetGauge().GetValue()
+				}
+			}
+		}
+	}
+	assert.Equal(t, map[string]float64{
+		"plant/p1/heartbeat": 1,
+		"plant/p2/heartbeat": 1,
+		"plant/+/heartbeat":  0,
+		"plant/#":            0,
+		"alarms/panel":       0,
+	}, stale)
+	assert.Len(t, gatherValues(t, registry, "mosquitto_topic_last_message_timestamp_seconds"), 3)
+}
+
+func TestLivenessCollector_OverlappingWatches(t *testing.T) {
+	collector := NewLivenessCollector(prometheus.Labels{"broker": "test-broker"}, LivenessOptions{
+		Watches: []LivenessWatch{
+			{Filter: "plant/+/heartbeat", Threshold: time.Minute},
+			{Filter: "plant/p1/heartbeat", Threshold: time.Hour},
+			{Filter: "plant/p1/heartbeat", Threshold: time.Hour},
+		},
+	})
+	registry := registryWith(t, collector)
+	client := newFakeClient("exporter")
+	collector.Subscribe(client)
+
+	// Before any message, each filter is exported once
+	assert.Len(t, gatherValues(t, registry, "mosquitto_topic_stale"), 2)
+
+	// The topic is tracked by the first watch, the literal filter of the
+	// other ones must not be exported again
+	client.publish("plant/p1/heartbeat", "")
+	require.Len(t, collector.Topics, 1)
+	assert.Equal(t, []float64{0}, gatherValues(t, registry, "mosquitto_topic_stale"))
+}
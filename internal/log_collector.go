@@ -0,0 +1,116 @@
+package internal
+
+import (
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const logTopic = "$SYS/broker/log/#"
+
+// logSeverities maps the log topic levels to severity label values.
+var logSeverities = map[string]string{
+	"E": "error",
+	"W": "warning",
+	"N": "notice",
+	"I": "information",
+	"D": "debug",
+	"M": "subscribe",
+	"S": "subscribe",
+}
+
+// logEvents maps a log line fragment to an event label value. They are
+// checked in order, so more specific fragments must come first.
+var logEvents = []struct {
+	fragment string
+	event    string
+}{
+	{"not authorised", "auth_failure"},
+	{"bad username or password", "auth_failure"},
+	{"has exceeded timeout", "client_timeout"},
+	{"Socket error on client", "socket_error"},
+	{"New connection from", "new_connection"},
+	{"New client connected from", "client_connected"},
+	{"closed its connection", "client_disconnected"},
+	{" disconnected", "client_disconnected"},
+}
+
+// LogCollector counts the log lines the broker publishes when 'log_dest topic'
+// is enabled, by severity and by event kind.
+type LogCollector struct {
+	mu          sync.RWMutex
+	Events      map[[2]string]float64
+	description metric
+}
+
+func init() {
+	registerCollector("log", false, "Enable the log collector, requires 'log_dest topic' on the broker.", func(labels prometheus.Labels, options CollectorOptions) Collector {
+		return NewLogCollector(labels)
+	})
+}
+
+func NewLogCollector(labels prometheus.Labels) *LogCollector {
+	return &LogCollector{
+		mu:     sync.RWMutex{},
+		Events: make(map[[2]string]float64, 16),
+		description: metric{
+			desc:      prometheus.NewDesc("mosquitto_log_events_total", "Number of log lines published by the broker", []string{"severity", "event"}, labels),
+			valueType: prometheus.CounterValue,
+		},
+	}
+}
+
+func (collector *LogCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- collector.description.desc
+}
+
+func (collector *LogCollector) Collect(ch chan<- prometheus.Metric) {
+	collector.mu.RLock()
+	for k, v := range collector.Events {
+		ch <- prometheus.MustNewConstMetric(collector.description.desc, collector.description.valueType, v, k[0], k[1])
+	}
+	collector.mu.RUnlock()
+}
+
+func (collector *LogCollector) Subscribe(client Client) {
+	if err := client.Subscribe(logTopic, 0, collector.logHandler); err != nil {
+		log.Printf("Failed to subscribe to %s: %v", logTopic, err)
+	}
+}
+
+func (collector *LogCollector) logHandler(client Client, message Message) {
+	// Topic is '$SYS/broker/log/<severity>', or '$SYS/broker/log/M/<action>'
+	// for subscriptions
+	topic := topicLevels(message.Topic())
+	if len(topic) < 4 {
+		return
+	}
+	severity := logSeverity(topic[3:])
+	event := logEvent(string(message.Payload()))
+	collector.mu.Lock()
+	collector.Events[[2]string{severity, event}]++
+	collector.mu.Unlock()
+}
+
+func logSeverity(levels []string) string {
+	if len(levels) > 1 && levels[0] == "M" {
+		return levels[1]
+	}
+	if severity, ok := logSeverities[levels[0]]; ok {
+		return severity
+	}
+	return strings.ToLower(levels[0])
+}
+
+// logEvent classifies a log line such as
+// '1700000000: New client connected from 10.0.0.1:51234 as sensor-1 (p2, c1, k60).'
+func logEvent(line string) string {
+	for _, e := range logEvents {
+		if strings.Contains(line, e.fragment) {
+			return e.event
+		}
+	}
+	return "other"
+}
@@ -0,0 +1,74 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewLogCollector(t *testing.T) {
+	labels := prometheus.Labels{"broker": "test-broker"}
+	collector := NewLogCollector(labels)
+
+	assert.NotNil(t, collector)
+	assert.NotNil(t, collector.Events)
+}
+
+func TestLogEvent(t *testing.T) {
+	testCases := []struct {
+		line  string
+		event string
+	}{
+		{"1700000000: New connection from 10.0.0.1:51234 on port 1883.", "new_connection"},
+		{"1700000000: New client connected from 10.0.0.1:51234 as sensor-1 (p2, c1, k60, u'device').", "client_connected"},
+		{"1700000000: Client sensor-1 disconnected.", "client_disconnected"},
+		{"1700000000: Client sensor-1 closed its connection.", "client_disconnected"},
+		{"1700000000: Client sensor-1 disconnected, not authorised.", "auth_failure"},
+		{"1700000000: Client sensor-1 has exceeded timeout, disconnecting.", "client_timeout"},
+		{"1700000000: Socket error on client sensor-1, disconnecting.", "socket_error"},
+		{"1700000000: Saving in-memory database to /mosquitto/data/mosquitto.db.", "other"},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.event, logEvent(tc.line), tc.line)
+	}
+}
+
+func TestLogSeverity(t *testing.T) {
+	assert.Equal(t, "error", logSeverity([]string{"E"}))
+	assert.Equal(t, "notice", logSeverity([]string{"N"}))
+	assert.Equal(t, "unsubscribe", logSeverity([]string{"M", "unsubscribe"}))
+	assert.Equal(t, "x", logSeverity([]string{"X"}))
+}
+
+func TestLogCollector_LogHandler(t *testing.T) {
+	labels := prometheus.Labels{"broker": "test-broker"}
+	collector := NewLogCollector(labels)
+	client := newFakeClient("exporter")
+	collector.Subscribe(client)
+
+	messages := []struct {
+		topic   string
+		payload string
+	}{
+		{"$SYS/broker/log/N", "1700000000: New connection from 10.0.0.1:51234 on port 1883."},
+		{"$SYS/broker/log/N", "1700000000: New connection from 10.0.0.2:51234 on port 1883."},
+		{"$SYS/broker/log/N", "1700000000: Client sensor-1 disconnected, not authorised."},
+		{"$SYS/broker/log/W", "1700000000: Client sensor-2 has exceeded timeout, disconnecting."},
+		{"$SYS/broker/log/M/subscribe", "1700000000: sensor-1 0 devices/#"},
+	}
+	for _, m := range messages {
+		client.publish(m.topic, m.payload)
+	}
+
+	assert.Equal(t, float64(2), collector.Events[[2]string{"notice", "new_connection"}])
+	assert.Equal(t, float64(1), collector.Events[[2]string{"notice", "auth_failure"}])
+	assert.Equal(t, float64(1), collector.Events[[2]string{"warning", "client_timeout"}])
+	assert.Equal(t, float64(1), collector.Events[[2]string{"subscribe", "other"}])
+
+	count, err := testutil.GatherAndCount(registryWith(t, collector), "mosquitto_log_events_total")
+	require.NoError(t, err)
+	assert.Equal(t, 4, count)
+}
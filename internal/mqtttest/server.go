@@ -0,0 +1,433 @@
+// Package mqtttest provides an in-process MQTT 3.1.1 server for tests. It
+// implements just enough of the protocol for the exporter: retained messages,
+// acknowledgements of QoS 1 and 2 publications and delivery at QoS 0, along
+// with a scripted $SYS tree and hooks to break connections and reject
+// subscriptions.
+package mqtttest
+
+import (
+	"net"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// subscriptionRejected is the SUBACK return code of a failed subscription.
+const subscriptionRejected = 0x80
+
+// Server is an MQTT server listening on a random local port.
+type Server struct {
+	listener net.Listener
+	done     chan struct{}
+	wg       sync.WaitGroup
+
+	mu            sync.Mutex
+	conns         map[*conn]bool
+	retained      map[string][]byte
+	rejected      map[string]bool
+	refuse        byte
+	handlers      []publishHandler
+	subscriptions []string
+	connects      int
+}
+
+type publishHandler struct {
+	filter  string
+	handler func(topic string, payload []byte)
+}
+
+type conn struct {
+	net.Conn
+	clientID string
+	writeMu  sync.Mutex
+	// filters is guarded by Server.mu
+	filters []string
+}
+
+func (c *conn) write(packet packets.ControlPacket) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return packet.Write(c.Conn)
+}
+
+// NewServer starts a server, which is closed at the end of the test.
+func NewServer(tb testing.TB) *Server {
+	tb.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatalf("mqtttest: failed to listen: %v", err)
+	}
+	server := &Server{
+		listener: listener,
+		done:     make(chan struct{}),
+		conns:    make(map[*conn]bool),
+		retained: make(map[string][]byte),
+		rejected: make(map[string]bool),
+	}
+	server.wg.Add(1)
+	go server.accept()
+	tb.Cleanup(server.Close)
+	return server
+}
+
+// URL returns the address clients connect to.
+func (s *Server) URL() string {
+	return "tcp://" + s.listener.Addr().String()
+}
+
+// Close stops the server and closes every connection.
+func (s *Server) Close() {
+	select {
+	case <-s.done:
+		return
+	default:
+		close(s.done)
+	}
+	s.listener.Close()
+	s.DropConnections()
+	s.wg.Wait()
+}
+
+// DropConnections closes the connections of every client, as a broker
+// restart or a network failure would.
+func (s *Server) DropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		c.Close()
+		delete(s.conns, c)
+	}
+}
+
+// RefuseConnections makes the server answer the next CONNECT packets with
+// code, 0 accepts them again. Code 5 means not authorized.
+func (s *Server) RefuseConnections(code byte) {
+	s.mu.Lock()
+	s.refuse = code
+	s.mu.Unlock()
+}
+
+// RejectSubscriptions makes the server reject the subscriptions to the given
+// topic filters.
+func (s *Server) RejectSubscriptions(filters ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, filter := range filters {
+		s.rejected[filter] = true
+	}
+}
+
+// HandlePublish calls handler for every message published by a client on a
+// topic matching filter, so tests can answer requests.
+func (s *Server) HandlePublish(filter string, handler func(topic string, payload []byte)) {
+	s.mu.Lock()
+	s.handlers = append(s.handlers, publishHandler{filter: filter, handler: handler})
+	s.mu.Unlock()
+}
+
+// Subscriptions returns the topic filters subscribed so far, in order,
+// including the ones subscribed again after a reconnection.
+func (s *Server) Subscriptions() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.subscriptions...)
+}
+
+// Subscribed returns the topic filters the connected clients are currently
+// subscribed to, a filter subscribed by several clients being repeated.
+func (s *Server) Subscribed() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var filters []string
+	for c := range s.conns {
+		filters = append(filters, c.filters...)
+	}
+	sort.Strings(filters)
+	return filters
+}
+
+// Retained returns the retained message of topic, if there is one.
+func (s *Server) Retained(topic string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	payload, ok := s.retained[topic]
+	return string(payload), ok
+}
+
+// Connects returns the number of accepted connections so far.
+func (s *Server) Connects() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connects
+}
+
+// Clients returns the client IDs of the connected clients.
+func (s *Server) Clients() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var clients []string
+	for c := range s.conns {
+		clients = append(clients, c.clientID)
+	}
+	sort.Strings(clients)
+	return clients
+}
+
+// Publish sends a message to the subscribed clients, retained messages are
+// also sent to later subscriptions. An empty retained message clears the
+// retained message of topic.
+func (s *Server) Publish(topic string, payload string, retain bool) {
+	s.publish(topic, []byte(payload), retain)
+}
+
+// PublishSys publishes every value of tree as a retained message, the way
+// Mosquitto publishes its $SYS tree.
+func (s *Server) PublishSys(tree map[string]string) {
+	topics := make([]string, 0, len(tree))
+	for topic := range tree {
+		topics = append(topics, topic)
+	}
+	// Uptime last, so receiving it means the whole tree was sent
+	sort.Slice(topics, func(i, j int) bool {
+		if (topics[i] == UptimeTopic) != (topics[j] == UptimeTopic) {
+			return topics[j] == UptimeTopic
+		}
+		return topics[i] < topics[j]
+	})
+	for _, topic := range topics {
+		s.Publish(topic, tree[topic], true)
+	}
+}
+
+// ReplaySys publishes the trees of script one after the other every
+// interval, then keeps publishing the last one, until the server is closed.
+func (s *Server) ReplaySys(interval time.Duration, script ...map[string]string) {
+	if len(script) == 0 {
+		return
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for i := 0; ; i++ {
+			s.PublishSys(script[min(i, len(script)-1)])
+			select {
+			case <-ticker.C:
+			case <-s.done:
+				return
+			}
+		}
+	}()
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		netConn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serve(&conn{Conn: netConn})
+		}()
+	}
+}
+
+func (s *Server) serve(c *conn) {
+	defer func() {
+		c.Close()
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+	}()
+
+	packet, err := packets.ReadPacket(c)
+	if err != nil {
+		return
+	}
+	connect, ok := packet.(*packets.ConnectPacket)
+	if !ok {
+		return
+	}
+	c.clientID = connect.ClientIdentifier
+	connack := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+	s.mu.Lock()
+	connack.ReturnCode = s.refuse
+	if connack.ReturnCode == packets.Accepted {
+		select {
+		case <-s.done:
+			s.mu.Unlock()
+			return
+		default:
+		}
+		s.conns[c] = true
+		s.connects++
+	}
+	s.mu.Unlock()
+	if c.write(connack) != nil || connack.ReturnCode != packets.Accepted {
+		return
+	}
+
+	for {
+		packet, err := packets.ReadPacket(c)
+		if err != nil {
+			return
+		}
+		switch p := packet.(type) {
+		case *packets.SubscribePacket:
+			s.subscribe(c, p)
+		case *packets.UnsubscribePacket:
+			s.unsubscribe(c, p)
+		case *packets.PublishPacket:
+			switch p.Qos {
+			case 1:
+				puback := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				puback.MessageID = p.MessageID
+				c.write(puback)
+			case 2:
+				pubrec := packets.NewControlPacket(packets.Pubrec).(*packets.PubrecPacket)
+				pubrec.MessageID = p.MessageID
+				c.write(pubrec)
+			}
+			s.publish(p.TopicName, p.Payload, p.Retain)
+			s.handle(p.TopicName, p.Payload)
+		case *packets.PubrelPacket:
+			pubcomp := packets.NewControlPacket(packets.Pubcomp).(*packets.PubcompPacket)
+			pubcomp.MessageID = p.MessageID
+			c.write(pubcomp)
+		case *packets.PingreqPacket:
+			c.write(packets.NewControlPacket(packets.Pingresp))
+		case *packets.DisconnectPacket:
+			return
+		}
+	}
+}
+
+func (s *Server) subscribe(c *conn, subscribe *packets.SubscribePacket) {
+	suback := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+	suback.MessageID = subscribe.MessageID
+	var granted []string
+	s.mu.Lock()
+	for _, filter := range subscribe.Topics {
+		if s.rejected[filter] {
+			suback.ReturnCodes = append(suback.ReturnCodes, subscriptionRejected)
+			continue
+		}
+		// Messages are always delivered at QoS 0
+		suback.ReturnCodes = append(suback.ReturnCodes, 0)
+		granted = append(granted, filter)
+		s.subscriptions = append(s.subscriptions, filter)
+		if !slices.Contains(c.filters, filter) {
+			c.filters = append(c.filters, filter)
+		}
+	}
+	var retained []*packets.PublishPacket
+	for topic, payload := range s.retained {
+		for _, filter := range granted {
+			if Match(filter, topic) {
+				retained = append(retained, publishPacket(topic, payload, true))
+				break
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	if c.write(suback) != nil {
+		return
+	}
+	for _, publish := range retained {
+		c.write(publish)
+	}
+}
+
+func (s *Server) unsubscribe(c *conn, unsubscribe *packets.UnsubscribePacket) {
+	s.mu.Lock()
+	filters := c.filters[:0]
+	for _, filter := range c.filters {
+		if !slices.Contains(unsubscribe.Topics, filter) {
+			filters = append(filters, filter)
+		}
+	}
+	c.filters = filters
+	s.mu.Unlock()
+	unsuback := packets.NewControlPacket(packets.Unsuback).(*packets.UnsubackPacket)
+	unsuback.MessageID = unsubscribe.MessageID
+	c.write(unsuback)
+}
+
+func (s *Server) publish(topic string, payload []byte, retain bool) {
+	s.mu.Lock()
+	if retain {
+		if len(payload) == 0 {
+			delete(s.retained, topic)
+		} else {
+			s.retained[topic] = payload
+		}
+	}
+	// Like Mosquitto, which allows duplicate messages by default, a copy is
+	// sent for every matching subscription of a connection.
+	var targets []*conn
+	for c := range s.conns {
+		for _, filter := range c.filters {
+			if Match(filter, topic) {
+				targets = append(targets, c)
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	// As in MQTT 3.1.1, messages delivered to existing subscriptions are not
+	// flagged as retained.
+	for _, c := range targets {
+		c.write(publishPacket(topic, payload, false))
+	}
+}
+
+func (s *Server) handle(topic string, payload []byte) {
+	s.mu.Lock()
+	var handlers []func(string, []byte)
+	for _, h := range s.handlers {
+		if Match(h.filter, topic) {
+			handlers = append(handlers, h.handler)
+		}
+	}
+	s.mu.Unlock()
+	for _, handler := range handlers {
+		handler(topic, payload)
+	}
+}
+
+func publishPacket(topic string, payload []byte, retain bool) *packets.PublishPacket {
+	publish := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	publish.TopicName = topic
+	publish.Payload = payload
+	publish.Retain = retain
+	return publish
+}
+
+// Match reports whether topic matches the MQTT topic filter. Wildcards at the
+// first level do not match topics starting with '$'.
+func Match(filter string, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	levels := strings.Split(topic, "/")
+	if strings.HasPrefix(topic, "$") && (filterLevels[0] == "+" || filterLevels[0] == "#") {
+		return false
+	}
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(levels) || (level != "+" && level != levels[i]) {
+			return false
+		}
+	}
+	return len(levels) == len(filterLevels)
+}
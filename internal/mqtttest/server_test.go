@@ -0,0 +1,26 @@
+package mqtttest
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		filter string
+		topic  string
+		want   bool
+	}{
+		{"$SYS/broker/uptime", "$SYS/broker/uptime", true},
+		{"$SYS/broker/clients/#", "$SYS/broker/clients/connected", true},
+		{"$SYS/broker/clients/#", "$SYS/broker/clients", true},
+		{"$SYS/broker/load/+/1min", "$SYS/broker/load/sockets/1min", true},
+		{"$SYS/broker/load/+/1min", "$SYS/broker/load/bytes/sent/1min", false},
+		{"devices/+", "devices/d1/heartbeat", false},
+		{"#", "$SYS/broker/uptime", false},
+		{"+/broker/uptime", "$SYS/broker/uptime", false},
+		{"#", "devices/d1", true},
+	}
+	for _, tt := range tests {
+		if got := Match(tt.filter, tt.topic); got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.filter, tt.topic, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package mqtttest
+
+import (
+	"maps"
+	"strconv"
+	"strings"
+)
+
+// UptimeTopic is published last by PublishSys, receiving it means the whole
+// tree was received.
+const UptimeTopic = "$SYS/broker/uptime"
+
+// SysTree returns the $SYS tree of a Mosquitto 2.0 broker running for uptime
+// seconds, with a bridge named "cloud". Tests can change or add values before
+// publishing it.
+func SysTree(uptime int) map[string]string {
+	tree := map[string]string{
+		UptimeTopic:                               strconv.Itoa(uptime) + " seconds",
+		"$SYS/broker/version":                     "mosquitto version 2.0.18",
+		"$SYS/broker/subscriptions/count":         "12",
+		"$SYS/broker/shared_subscriptions/count":  "2",
+		"$SYS/broker/clients/active":              "5",
+		"$SYS/broker/clients/connected":           "5",
+		"$SYS/broker/clients/disconnected":        "1",
+		"$SYS/broker/clients/expired":             "0",
+		"$SYS/broker/clients/inactive":            "1",
+		"$SYS/broker/clients/maximum":             "7",
+		"$SYS/broker/clients/total":               "6",
+		"$SYS/broker/messages/received":           "1200",
+		"$SYS/broker/messages/sent":               "2400",
+		"$SYS/broker/messages/inflight":           "3",
+		"$SYS/broker/store/messages/count":        "42",
+		"$SYS/broker/store/messages/bytes":        "8192",
+		"$SYS/broker/retained messages/count":     "42",
+		"$SYS/broker/heap/current":                "52496",
+		"$SYS/broker/heap/maximum":                "78112",
+		"$SYS/broker/bytes/received":              "123456",
+		"$SYS/broker/bytes/sent":                  "654321",
+		"$SYS/broker/publish/messages/received":   "1100",
+		"$SYS/broker/publish/messages/sent":       "2300",
+		"$SYS/broker/publish/messages/dropped":    "4",
+		"$SYS/broker/publish/bytes/received":      "65536",
+		"$SYS/broker/publish/bytes/sent":          "131072",
+		"$SYS/broker/connection/cloud/state":      "1",
+		"$SYS/broker/load/connections/1min":       "0.5",
+		"$SYS/broker/load/sockets/1min":           "0.6",
+		"$SYS/broker/load/bytes/received/1min":    "2048.0",
+		"$SYS/broker/load/bytes/sent/1min":        "4096.0",
+		"$SYS/broker/load/messages/received/1min": "20.0",
+		"$SYS/broker/load/messages/sent/1min":     "40.0",
+		"$SYS/broker/load/publish/received/1min":  "18.0",
+		"$SYS/broker/load/publish/sent/1min":      "38.0",
+		"$SYS/broker/load/publish/dropped/1min":   "0.1",
+	}
+	// The 5 and 15 minutes averages follow the 1 minute ones
+	for topic, value := range maps.Clone(tree) {
+		if prefix, ok := strings.CutSuffix(topic, "/1min"); ok {
+			tree[prefix+"/5min"] = value
+			tree[prefix+"/15min"] = value
+		}
+	}
+	return tree
+}
@@ -0,0 +1,271 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+)
+
+// PayloadRule turns a field of the JSON messages published on a topic filter
+// into a metric.
+type PayloadRule struct {
+	Filter string `yaml:"filter"`
+	// Selector of the value, such as '$.sensors[0].value'.
+	Selector string `yaml:"selector"`
+	Metric   string `yaml:"metric"`
+	// Type is gauge (the default) or counter. Counters are exported as
+	// published, the device is expected to send a cumulative value.
+	Type string `yaml:"type"`
+	Help string `yaml:"help"`
+	// Labels maps label names to their source: 'topic:N' for the N-th
+	// wildcard of the filter (starting at 1), or a selector starting with
+	// '$' for a payload field.
+	Labels map[string]string `yaml:"labels"`
+}
+
+// PayloadOptions configures the payload collector.
+type PayloadOptions struct {
+	Rules []PayloadRule `yaml:"rules"`
+	// Expire drops the series not updated for this long, 0 keeps them.
+	Expire time.Duration `yaml:"expire"`
+	// MaxSeries caps the number of series per rule, 0 for no limit.
+	MaxSeries int `yaml:"max_series"`
+}
+
+func (o PayloadOptions) validate() error {
+	metrics := make(map[string]bool, len(o.Rules))
+	for _, rule := range o.Rules {
+		if _, err := compilePayloadRule(rule); err != nil {
+			return fmt.Errorf("payload: %w", err)
+		}
+		if metrics[rule.Metric] {
+			return fmt.Errorf("payload: metric %q is defined by several rules", rule.Metric)
+		}
+		metrics[rule.Metric] = true
+	}
+	if o.Expire < 0 {
+		return errNegativeDuration("payload.expire")
+	}
+	if o.MaxSeries < 0 {
+		return fmt.Errorf("payload.max_series must not be negative")
+	}
+	return nil
+}
+
+// payloadLabel is the source of a label value, either a wildcard of the
+// topic filter or a payload field.
+type payloadLabel struct {
+	name     string
+	wildcard int
+	selector selector
+}
+
+type payloadRule struct {
+	filter    string
+	selector  selector
+	valueType prometheus.ValueType
+	labels    []payloadLabel
+}
+
+func compilePayloadRule(rule PayloadRule) (*payloadRule, error) {
+	if err := validTopicFilter(rule.Filter); err != nil {
+		return nil, err
+	}
+	if !model.LegacyValidation.IsValidMetricName(rule.Metric) {
+		return nil, fmt.Errorf("invalid metric name %q", rule.Metric)
+	}
+	selector, err := parseSelector(rule.Selector)
+	if err != nil {
+		return nil, fmt.Errorf("metric %s: %w", rule.Metric, err)
+	}
+	compiled := &payloadRule{filter: rule.Filter, selector: selector}
+	switch rule.Type {
+	case "", "gauge":
+		compiled.valueType = prometheus.GaugeValue
+	case "counter":
+		compiled.valueType = prometheus.CounterValue
+	default:
+		return nil, fmt.Errorf("metric %s: unknown type %q", rule.Metric, rule.Type)
+	}
+
+	wildcards := filterWildcards(rule.Filter)
+	for _, name := range sortedLabelNames(rule.Labels) {
+		source := rule.Labels[name]
+		if !model.LegacyValidation.IsValidLabelName(name) || name == "broker" {
+			return nil, fmt.Errorf("metric %s: invalid label name %q", rule.Metric, name)
+		}
+		label := payloadLabel{name: name}
+		if strings.HasPrefix(source, "topic:") {
+			n, err := strconv.Atoi(strings.TrimPrefix(source, "topic:"))
+			if err != nil || n < 1 || n > wildcards {
+				return nil, fmt.Errorf("metric %s: label %s refers to a missing wildcard of %q", rule.Metric, name, rule.Filter)
+			}
+			label.wildcard = n
+		} else if label.selector, err = parseSelector(source); err != nil || !strings.HasPrefix(source, "$") {
+			return nil, fmt.Errorf("metric %s: label %s must be 'topic:N' or a selector starting with '$'", rule.Metric, name)
+		}
+		compiled.labels = append(compiled.labels, label)
+	}
+	return compiled, nil
+}
+
+type payloadSample struct {
+	labelValues []string
+	value       float64
+	lastSeen    time.Time
+}
+
+// PayloadCollector turns the JSON messages published on application topics
+// into metrics, following a list of rules.
+type PayloadCollector struct {
+	mu      sync.RWMutex
+	rules   []*payloadRule
+	descs   []*prometheus.Desc
+	filters []string
+	// Samples per rule, keyed by label values
+	Samples   []map[string]*payloadSample
+	expire    time.Duration
+	maxSeries int
+	now       func() time.Time
+}
+
+func init() {
+	// The rules can only be set in the configuration file
+	registerCollector("payload", false, "", func(labels prometheus.Labels, options CollectorOptions) Collector {
+		return NewPayloadCollector(labels, options.Payload)
+	})
+}
+
+func NewPayloadCollector(labels prometheus.Labels, options PayloadOptions) *PayloadCollector {
+	collector := &PayloadCollector{
+		mu:        sync.RWMutex{},
+		expire:    options.Expire,
+		maxSeries: options.MaxSeries,
+		now:       time.Now,
+	}
+	for _, rule := range options.Rules {
+		compiled, err := compilePayloadRule(rule)
+		if err != nil {
+			// Options are validated when the broker is created
+			log.Printf("Ignoring payload rule: %v", err)
+			continue
+		}
+		labelNames := make([]string, 0, len(compiled.labels))
+		for _, label := range compiled.labels {
+			labelNames = append(labelNames, label.name)
+		}
+		help := rule.Help
+		if help == "" {
+			help = fmt.Sprintf("Value of %s in the messages published on %s", rule.Selector, rule.Filter)
+		}
+		collector.rules = append(collector.rules, compiled)
+		collector.descs = append(collector.descs, prometheus.NewDesc(rule.Metric, help, labelNames, labels))
+		collector.Samples = append(collector.Samples, make(map[string]*payloadSample))
+		if !slices.Contains(collector.filters, rule.Filter) {
+			collector.filters = append(collector.filters, rule.Filter)
+		}
+	}
+	return collector
+}
+
+func (collector *PayloadCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range collector.descs {
+		ch <- desc
+	}
+}
+
+func (collector *PayloadCollector) Collect(ch chan<- prometheus.Metric) {
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+
+	now := collector.now()
+	for i, samples := range collector.Samples {
+		for key, sample := range samples {
+			if collector.expire > 0 && now.Sub(sample.lastSeen) > collector.expire {
+				delete(samples, key)
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(collector.descs[i], collector.rules[i].valueType, sample.value, sample.labelValues...)
+		}
+	}
+}
+
+func (collector *PayloadCollector) Subscribe(client Client) {
+	for i, filter := range collector.filters {
+		if err := client.Subscribe(filter, 0, firstFilterHandler(collector.filters, i, collector.messageHandler)); err != nil {
+			log.Printf("Failed to subscribe to %s: %v", filter, err)
+		}
+	}
+}
+
+func (collector *PayloadCollector) messageHandler(client Client, message Message) {
+	var document interface{}
+	if err := json.Unmarshal(message.Payload(), &document); err != nil {
+		return
+	}
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	for i, rule := range collector.rules {
+		if !topicMatches(rule.filter, message.Topic()) {
+			continue
+		}
+		selected, ok := rule.selector.lookup(document)
+		if !ok {
+			continue
+		}
+		value, ok := selectorNumber(selected)
+		if !ok {
+			continue
+		}
+		labelValues, ok := rule.labelValues(message.Topic(), document)
+		if !ok {
+			continue
+		}
+		key := strings.Join(labelValues, "\xff")
+		sample, ok := collector.Samples[i][key]
+		if !ok {
+			if collector.maxSeries > 0 && len(collector.Samples[i]) >= collector.maxSeries {
+				continue
+			}
+			sample = &payloadSample{labelValues: labelValues}
+			collector.Samples[i][key] = sample
+		}
+		sample.value = value
+		sample.lastSeen = collector.now()
+	}
+}
+
+// labelValues returns the values of the labels of the rule, in the order of
+// their names.
+func (rule *payloadRule) labelValues(topic string, document interface{}) ([]string, bool) {
+	values := make([]string, 0, len(rule.labels))
+	var wildcards []string
+	for _, label := range rule.labels {
+		if label.wildcard > 0 {
+			if wildcards == nil {
+				wildcards = topicWildcards(rule.filter, topic)
+			}
+			values = append(values, wildcards[label.wildcard-1])
+			continue
+		}
+		selected, ok := label.selector.lookup(document)
+		if !ok {
+			return nil, false
+		}
+		value, ok := selectorString(selected)
+		if !ok {
+			return nil, false
+		}
+		values = append(values, value)
+	}
+	return values, true
+}
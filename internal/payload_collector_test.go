@@ -0,0 +1,119 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testPayloadRules = []PayloadRule{
+	{
+		Filter:   "devices/+/telemetry",
+		Selector: "$.temperature",
+		Metric:   "device_temperature_celsius",
+		Labels:   map[string]string{"device": "topic:1", "room": "$.location.room"},
+	},
+	{
+		Filter:   "devices/+/telemetry",
+		Selector: "$.counters.reboots",
+		Metric:   "device_reboots_total",
+		Type:     "counter",
+		Labels:   map[string]string{"device": "topic:1"},
+	},
+	{
+		Filter:   "sites/#",
+		Selector: "$.online",
+		Metric:   "site_online",
+		Labels:   map[string]string{"path": "topic:1"},
+	},
+}
+
+func TestNewPayloadCollector(t *testing.T) {
+	labels := prometheus.Labels{"broker": "test-broker"}
+	collector := NewPayloadCollector(labels, PayloadOptions{Rules: testPayloadRules})
+
+	assert.NotNil(t, collector)
+	assert.Len(t, collector.rules, 3)
+	assert.Len(t, collector.descs, 3)
+	// Filters shared by several rules are subscribed once
+	assert.Equal(t, []string{"devices/+/telemetry", "sites/#"}, collector.filters)
+}
+
+func TestPayloadOptions_Validate(t *testing.T) {
+	assert.NoError(t, PayloadOptions{Rules: testPayloadRules}.validate())
+
+	for _, rule := range []PayloadRule{
+		{Filter: "devices/#/x", Selector: "$.t", Metric: "m"},
+		{Filter: "devices/+", Selector: "$.t", Metric: "invalid-name"},
+		{Filter: "devices/+", Selector: "$.t[", Metric: "m"},
+		{Filter: "devices/+", Selector: "$.t", Metric: "m", Type: "histogram"},
+		{Filter: "devices/+", Selector: "$.t", Metric: "m", Labels: map[string]string{"device": "topic:2"}},
+		{Filter: "devices/+", Selector: "$.t", Metric: "m", Labels: map[string]string{"room": "location.room"}},
+		{Filter: "devices/+", Selector: "$.t", Metric: "m", Labels: map[string]string{"broker": "topic:1"}},
+	} {
+		assert.Error(t, PayloadOptions{Rules: []PayloadRule{rule}}.validate(), rule)
+	}
+	assert.Error(t, PayloadOptions{Rules: []PayloadRule{testPayloadRules[0], testPayloadRules[0]}}.validate())
+	assert.Error(t, PayloadOptions{Expire: -time.Second}.validate())
+}
+
+func TestModuleConfig_ValidatePayloadMetrics(t *testing.T) {
+	module := func(rule PayloadRule) ModuleConfig {
+		return ModuleConfig{
+			Collectors: []string{"payload"},
+			Labels:     map[string]string{"site": "paris"},
+			Options: &CollectorOptions{
+				Payload: PayloadOptions{Rules: []PayloadRule{rule}},
+				Sys:     SysOptions{Mappings: []SysMapping{{Topic: "$SYS/broker/memory/used", Metric: "mosquitto_memory_bytes"}}},
+			},
+		}
+	}
+	assert.NoError(t, module(testPayloadRules[0]).validate())
+
+	for _, metric := range []string{"mosquitto_up", "mosquitto_uptime_seconds", "mosquitto_memory_bytes"} {
+		rule := PayloadRule{Filter: "devices/+", Selector: "$.t", Metric: metric}
+		assert.Error(t, module(rule).validate(), metric)
+	}
+	rule := PayloadRule{Filter: "devices/+", Selector: "$.t", Metric: "m", Labels: map[string]string{"site": "topic:1"}}
+	assert.Error(t, module(rule).validate())
+}
+
+func TestPayloadCollector_MessageHandler(t *testing.T) {
+	labels := prometheus.Labels{"broker": "test-broker"}
+	collector := NewPayloadCollector(labels, PayloadOptions{Rules: testPayloadRules, Expire: time.Minute, MaxSeries: 2})
+	now := time.Now()
+	collector.now = func() time.Time { return now }
+	registry := registryWith(t, collector)
+	client := newFakeClient("exporter")
+	collector.Subscribe(client)
+	client.publish("devices/d1/telemetry", `{"temperature":21.5,"location":{"room":"kitchen"},"counters":{"reboots":3}}`)
+	client.publish("devices/d2/telemetry", `{"temperature":"19","location":{"room":"office"}}`)
+	// Missing label field, invalid JSON and the series limit
+	client.publish("devices/d3/telemetry", `{"temperature":20,"counters":{"reboots":1}}`)
+	client.publish("devices/d4/telemetry", `not json`)
+	client.publish("devices/d5/telemetry", `{"temperature":18,"location":{"room":"hall"}}`)
+	client.publish("sites/paris/gateway", `{"online":true}`)
+
+	assert.Len(t, collector.Samples[0], 2)
+	assert.Len(t, collector.Samples[1], 2)
+	assert.Equal(t, []float64{21.5, 19}, gatherValues(t, registry, "device_temperature_celsius"))
+	assert.Equal(t, []float64{1}, gatherValues(t, registry, "site_online"))
+
+	expected := `
+# HELP device_reboots_total Value of $.counters.reboots in the messages published on devices/+/telemetry
+# TYPE device_reboots_total counter
+device_reboots_total{broker="test-broker",device="d1"} 3
+device_reboots_total{broker="test-broker",device="d3"} 1
+`
+	require.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "device_reboots_total"))
+
+	// Expired series are dropped
+	now = now.Add(2 * time.Minute)
+	client.publish("devices/d1/telemetry", `{"temperature":22,"location":{"room":"kitchen"},"counters":{"reboots":3}}`)
+	assert.Equal(t, []float64{22}, gatherValues(t, registry, "device_temperature_celsius"))
+}
@@ -0,0 +1,284 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	defaultPersistenceTopic    = "mosquitto_exporter/retained"
+	defaultPersistenceInterval = time.Minute
+	defaultPersistenceTimeout  = 5 * time.Second
+)
+
+// PersistenceOptions configures the retained message probe.
+type PersistenceOptions struct {
+	// Topic of the retained message, defaults to
+	// 'mosquitto_exporter/retained/<client ID>', followed by '/probe' for the
+	// /probe endpoint.
+	Topic string `yaml:"topic"`
+	// Interval between two probes, defaults to one minute.
+	Interval time.Duration `yaml:"interval"`
+	// Timeout of every step of a probe, defaults to 5 seconds.
+	Timeout time.Duration `yaml:"timeout"`
+	// CheckRestarts verifies the retained message survives broker restarts.
+	CheckRestarts bool `yaml:"check_restarts"`
+}
+
+func (o PersistenceOptions) validate() error {
+	if strings.ContainsAny(o.Topic, "+#") {
+		return fmt.Errorf("persistence.topic must not contain wildcards")
+	}
+	if o.Interval < 0 {
+		return errNegativeDuration("persistence.interval")
+	}
+	if o.Timeout < 0 {
+		return errNegativeDuration("persistence.timeout")
+	}
+	return nil
+}
+
+// PersistenceCollector periodically publishes a retained message and checks
+// that a second short-lived client receives it. When restarts are checked,
+// the last value published before a restart must be delivered after it.
+type PersistenceCollector struct {
+	mu            sync.Mutex
+	topic         string
+	interval      time.Duration
+	timeout       time.Duration
+	checkRestarts bool
+	newClient     clientFactory
+
+	restarted bool
+	// Last value published, expected after a restart
+	value   string
+	success map[string]float64
+	running bool
+
+	duration    *prometheus.HistogramVec
+	successDesc *prometheus.Desc
+	restartCh   chan struct{}
+	once        sync.Once
+	done        chan struct{}
+	stopped     chan struct{}
+}
+
+func init() {
+	registerCollector("persistence", false, "Enable the retained message probe.", func(labels prometheus.Labels, options CollectorOptions) Collector {
+		return NewPersistenceCollector(labels, options.Persistence)
+	})
+}
+
+func NewPersistenceCollector(labels prometheus.Labels, options PersistenceOptions) *PersistenceCollector {
+	collector := &PersistenceCollector{
+		topic:         options.Topic,
+		interval:      options.Interval,
+		timeout:       options.Timeout,
+		checkRestarts: options.CheckRestarts,
+		success:       make(map[string]float64, 2),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:        "mosquitto_retained_probe_duration_seconds",
+			Help:        "Time between publishing a retained message and receiving it with a new client",
+			ConstLabels: labels,
+			Buckets:     prometheus.ExponentialBuckets(0.001, 2, 13),
+		}, []string{"check"}),
+		successDesc: prometheus.NewDesc("mosquitto_retained_probe_success", "Whether the last retained message check succeeded", []string{"check"}, labels),
+		restartCh:   make(chan struct{}, 1),
+		done:        make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+	if collector.interval <= 0 {
+		collector.interval = defaultPersistenceInterval
+	}
+	if collector.timeout <= 0 {
+		collector.timeout = defaultPersistenceTimeout
+	}
+	return collector
+}
+
+func (collector *PersistenceCollector) Describe(ch chan<- *prometheus.Desc) {
+	collector.duration.Describe(ch)
+	ch <- collector.successDesc
+}
+
+func (collector *PersistenceCollector) Collect(ch chan<- prometheus.Metric) {
+	collector.duration.Collect(ch)
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	for check, v := range collector.success {
+		ch <- prometheus.MustNewConstMetric(collector.successDesc, prometheus.GaugeValue, v, check)
+	}
+}
+
+// Subscribe starts probing on the first call, the probe messages are read by
+// short-lived clients.
+func (collector *PersistenceCollector) Subscribe(client Client) {
+	collector.once.Do(func() {
+		collector.mu.Lock()
+		if collector.topic == "" {
+			collector.topic = defaultPersistenceTopic + "/" + client.ClientID()
+		}
+		collector.running = true
+		collector.mu.Unlock()
+		go collector.loop(client)
+	})
+}
+
+// Stop ends the periodic probes and waits for the retained message to be
+// removed.
+func (collector *PersistenceCollector) Stop() {
+	select {
+	case <-collector.done:
+		return
+	default:
+		close(collector.done)
+	}
+	collector.mu.Lock()
+	running := collector.running
+	collector.mu.Unlock()
+	if running {
+		<-collector.stopped
+	}
+}
+
+func (collector *PersistenceCollector) setClientFactory(factory clientFactory) {
+	collector.newClient = factory
+}
+
+// brokerRestarted probes at once, checking the value published before the
+// restart when restarts are checked.
+func (collector *PersistenceCollector) brokerRestarted() {
+	collector.mu.Lock()
+	if collector.checkRestarts && collector.value != "" {
+		collector.restarted = true
+	}
+	collector.mu.Unlock()
+	select {
+	case collector.restartCh <- struct{}{}:
+	default:
+	}
+}
+
+func (collector *PersistenceCollector) loop(client Client) {
+	defer close(collector.stopped)
+	ticker := time.NewTicker(collector.interval)
+	defer ticker.Stop()
+	for {
+		if client.IsConnectionOpen() {
+			collector.probe(client)
+		}
+		select {
+		case <-ticker.C:
+		case <-collector.restartCh:
+		case <-collector.done:
+			collector.clear(client)
+			return
+		}
+	}
+}
+
+// clear removes the retained message, so that none is left behind once the
+// collector is stopped.
+func (collector *PersistenceCollector) clear(client Client) {
+	collector.mu.Lock()
+	published := collector.value != ""
+	collector.mu.Unlock()
+	if !published || !client.IsConnectionOpen() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), collector.timeout)
+	defer cancel()
+	if err := client.Publish(ctx, collector.topic, 1, true, nil); err != nil {
+		log.Printf("Failed to remove retained message from %s: %v", collector.topic, err)
+	}
+}
+
+// probe checks the value published before a restart if there was one, then
+// publishes a new value and checks it.
+func (collector *PersistenceCollector) probe(client Client) {
+	collector.mu.Lock()
+	restarted, previous := collector.restarted, collector.value
+	collector.restarted = false
+	collector.mu.Unlock()
+	if restarted {
+		start := time.Now()
+		ok := collector.receive(previous)
+		collector.record("restart", ok, time.Since(start))
+	}
+
+	id := make([]byte, 8)
+	rand.Read(id)
+	value := hex.EncodeToString(id)
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), collector.timeout)
+	defer cancel()
+	if err := client.Publish(ctx, collector.topic, 1, true, []byte(value)); err != nil {
+		log.Printf("Failed to publish retained message to %s: %v", collector.topic, err)
+		collector.record("publish", false, 0)
+		return
+	}
+	collector.mu.Lock()
+	collector.value = value
+	collector.mu.Unlock()
+	ok := collector.receive(value)
+	collector.record("publish", ok, time.Since(start))
+}
+
+// receive connects a new client and waits for the retained message, it
+// returns whether its value is the expected one.
+func (collector *PersistenceCollector) receive(expected string) bool {
+	if collector.newClient == nil {
+		return false
+	}
+	client, err := collector.newClient("-retained")
+	if err != nil {
+		log.Printf("Failed to create retained probe client: %v", err)
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), collector.timeout)
+	defer cancel()
+	if err := client.Connect(ctx); err != nil {
+		log.Printf("Retained probe client failed to connect: %v", err)
+		return false
+	}
+	defer client.Disconnect(0)
+
+	values := make(chan string, 1)
+	err = client.Subscribe(collector.topic, 1, func(client Client, message Message) {
+		if !message.Retained() {
+			return
+		}
+		select {
+		case values <- string(message.Payload()):
+		default:
+		}
+	})
+	if err != nil {
+		log.Printf("Failed to subscribe to %s: %v", collector.topic, err)
+		return false
+	}
+	select {
+	case value := <-values:
+		return value == expected
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (collector *PersistenceCollector) record(check string, ok bool, duration time.Duration) {
+	collector.mu.Lock()
+	collector.success[check] = boolToFloat(ok)
+	collector.mu.Unlock()
+	if ok {
+		collector.duration.WithLabelValues(check).Observe(duration.Seconds())
+	}
+}
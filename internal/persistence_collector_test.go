@@ -0,0 +1,128 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewPersistenceCollector(t *testing.T) {
+	labels := prometheus.Labels{"broker": "test-broker"}
+	collector := NewPersistenceCollector(labels, PersistenceOptions{})
+
+	assert.NotNil(t, collector)
+	assert.Equal(t, defaultPersistenceInterval, collector.interval)
+	assert.Equal(t, defaultPersistenceTimeout, collector.timeout)
+	assert.Empty(t, collector.success)
+}
+
+func TestPersistenceCollector_BrokerRestarted(t *testing.T) {
+	labels := prometheus.Labels{"broker": "test-broker"}
+	collector := NewPersistenceCollector(labels, PersistenceOptions{CheckRestarts: true})
+
+	// Nothing was published yet, so there is nothing to check
+	collector.brokerRestarted()
+	assert.False(t, collector.restarted)
+	assert.Len(t, collector.restartCh, 1)
+
+	collector.value = "abcd"
+	collector.brokerRestarted()
+	assert.True(t, collector.restarted)
+	assert.Len(t, collector.restartCh, 1)
+
+	collector = NewPersistenceCollector(labels, PersistenceOptions{})
+	collector.value = "abcd"
+	collector.brokerRestarted()
+	assert.False(t, collector.restarted)
+}
+
+func TestPersistenceCollector_Record(t *testing.T) {
+	labels := prometheus.Labels{"broker": "test-broker"}
+	collector := NewPersistenceCollector(labels, PersistenceOptions{})
+	registry := registryWith(t, collector)
+
+	collector.record("publish", true, 20*time.Millisecond)
+	collector.record("restart", false, 0)
+	assert.Equal(t, map[string]float64{"publish": 1, "restart": 0}, collector.success)
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		if family.GetName() == "mosquitto_retained_probe_duration_seconds" {
+			require.Len(t, family.GetMetric(), 1)
+			assert.Equal(t, uint64(1), family.GetMetric()[0].GetHistogram().GetSampleCount())
+		}
+	}
+
+	// Without a client the check fails
+	assert.False(t, collector.receive("abcd"))
+	collector.setClientFactory(func(string) (connection, error) { return nil, errors.New("boom") })
+	assert.False(t, collector.receive("abcd"))
+
+	assert.Error(t, PersistenceOptions{Topic: "retained/+"}.validate())
+	assert.Error(t, PersistenceOptions{Interval: -time.Second}.validate())
+}
+
+func TestPersistenceCollector_Probe(t *testing.T) {
+	labels := prometheus.Labels{"broker": "test-broker"}
+	collector := NewPersistenceCollector(labels, PersistenceOptions{Timeout: time.Second, CheckRestarts: true})
+	broker := newFakeBroker()
+	client := broker.client("exporter")
+	collector.setClientFactory(func(suffix string) (connection, error) {
+		return broker.client("exporter" + suffix), nil
+	})
+	collector.topic = defaultPersistenceTopic + "/exporter"
+
+	collector.probe(client)
+	assert.Equal(t, map[string]float64{"publish": 1}, collector.success)
+	require.Len(t, client.publishedOn(collector.topic), 1)
+	assert.Equal(t, collector.value, client.publishedOn(collector.topic)[0])
+
+	// The retained message survived the restart
+	collector.brokerRestarted()
+	collector.probe(client)
+	assert.Equal(t, map[string]float64{"publish": 1, "restart": 1}, collector.success)
+
+	// The broker lost its retained messages
+	collector.brokerRestarted()
+	delete(broker.retained, collector.topic)
+	broker.client("other").Publish(context.Background(), collector.topic, 1, true, []byte("stale"))
+	collector.probe(client)
+	assert.Equal(t, map[string]float64{"publish": 1, "restart": 0}, collector.success)
+}
+
+func TestPersistenceCollector_PublishError(t *testing.T) {
+	labels := prometheus.Labels{"broker": "test-broker"}
+	collector := NewPersistenceCollector(labels, PersistenceOptions{Timeout: time.Second})
+	client := newFakeClient("exporter")
+	client.publishErr = errors.New("not authorized")
+	collector.topic = defaultPersistenceTopic + "/exporter"
+
+	collector.probe(client)
+	assert.Equal(t, map[string]float64{"publish": 0}, collector.success)
+}
+
+func TestPersistenceCollector_Stop(t *testing.T) {
+	labels := prometheus.Labels{"broker": "test-broker"}
+	collector := NewPersistenceCollector(labels, PersistenceOptions{Timeout: time.Second})
+	broker := newFakeBroker()
+	client := broker.client("exporter")
+	collector.setClientFactory(func(suffix string) (connection, error) {
+		return broker.client("exporter" + suffix), nil
+	})
+
+	collector.Subscribe(client)
+	assert.Eventually(t, func() bool {
+		return len(client.publishedOn(defaultPersistenceTopic+"/exporter")) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// The retained message is removed
+	collector.Stop()
+	assert.Equal(t, "", client.publishedOn(defaultPersistenceTopic + "/exporter")[1])
+	assert.Empty(t, broker.retained)
+}
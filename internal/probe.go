@@ -0,0 +1,216 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const defaultProbeTimeout = 10 * time.Second
+
+// ProbeHandler implements the multi-target pattern: every request to
+// /probe?target=<broker>&module=<module> connects to the target, waits for a
+// full $SYS interval and serves the collected metrics from a fresh registry.
+type ProbeHandler struct {
+	mu      sync.RWMutex
+	modules map[string]ModuleConfig
+}
+
+// NewProbeHandler creates a ProbeHandler using the given modules. The
+// "default" module is used when the request does not name one.
+func NewProbeHandler(modules map[string]ModuleConfig) *ProbeHandler {
+	return &ProbeHandler{modules: modules}
+}
+
+// SetModules replaces the modules available to probes.
+func (h *ProbeHandler) SetModules(modules map[string]ModuleConfig) {
+	h.mu.Lock()
+	h.modules = modules
+	h.mu.Unlock()
+}
+
+func (h *ProbeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	target := params.Get("target")
+	if target == "" {
+		http.Error(w, "target parameter is missing", http.StatusBadRequest)
+		return
+	}
+	// The target becomes the broker label
+	if !utf8.ValidString(target) {
+		http.Error(w, "target parameter is not valid UTF-8", http.StatusBadRequest)
+		return
+	}
+	moduleName := params.Get("module")
+	if moduleName == "" {
+		moduleName = "default"
+	}
+	h.mu.RLock()
+	module, ok := h.modules[moduleName]
+	h.mu.RUnlock()
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown module %q", moduleName), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), probeTimeout(r))
+	defer cancel()
+
+	registry := prometheus.NewRegistry()
+	cfg := BrokerConfig{
+		Name:         target,
+		URL:          probeURL(target, module),
+		ModuleConfig: module,
+	}
+	if err := Probe(ctx, cfg, registry); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// Probe connects to the broker described by cfg, subscribes its collectors and
+// waits until a full $SYS interval was received or ctx is done, then
+// registers the collected values in registry and disconnects. Failing to
+// connect is not an error, it is reported through mosquitto_up, but metrics
+// that cannot be registered are.
+func Probe(ctx context.Context, cfg BrokerConfig, registry *prometheus.Registry) error {
+	// Use a unique client ID so the probe never takes over the session of
+	// a long-running exporter connected with the same settings.
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	if cfg.ClientID == "" {
+		cfg.ClientID = "mosquitto-exporter"
+	}
+	// The retained message of the persistence collector does not depend on
+	// the random client ID, nor replace the one of such an exporter.
+	options := CollectorOptions{}
+	if cfg.Options != nil {
+		options = *cfg.Options
+	}
+	if options.Persistence.Topic == "" {
+		options.Persistence.Topic = defaultPersistenceTopic + "/" + cfg.ClientID + "/probe"
+	}
+	cfg.Options = &options
+	cfg.ClientID += "-probe-" + hex.EncodeToString(suffix)
+
+	broker, err := newBroker(cfg, false)
+	if err != nil {
+		return err
+	}
+	labels := prometheus.Labels{"broker": cfg.Label()}
+	durationDesc := prometheus.NewDesc("mosquitto_probe_duration_seconds", "Duration of the probe in seconds", nil, labels)
+	completeDesc := prometheus.NewDesc("mosquitto_probe_sys_complete", "Whether a full $SYS interval was received before the probe timed out", nil, labels)
+	start := time.Now()
+
+	// The first uptime message is the retained value delivered on subscribe,
+	// the second one closes a full $SYS interval. Without the default
+	// collector the uptime is not received and the probe does not wait.
+	uptimes := make(chan struct{}, 2)
+	if broker.defaults != nil {
+		broker.defaults.OnUptime(func(float64) {
+			select {
+			case uptimes <- struct{}{}:
+			default:
+			}
+		})
+	}
+
+	complete := false
+	err = broker.client.Connect(ctx)
+	defer func() {
+		broker.stop()
+		broker.client.Disconnect(0)
+	}()
+	if err != nil {
+		log.Printf("Probe of broker %s failed to connect: %v", cfg.Label(), err)
+	} else {
+		broker.up.SetUp(true)
+		broker.subscribe()
+		if broker.defaults != nil {
+			complete = waitUptimes(ctx, uptimes, 2)
+		}
+	}
+
+	if err := registry.Register(broker); err != nil {
+		return err
+	}
+	duration, err := prometheus.NewConstMetric(durationDesc, prometheus.GaugeValue, time.Since(start).Seconds())
+	if err != nil {
+		return err
+	}
+	completed, err := prometheus.NewConstMetric(completeDesc, prometheus.GaugeValue, boolToFloat(complete))
+	if err != nil {
+		return err
+	}
+	return registry.Register(staticCollector{duration, completed})
+}
+
+func waitUptimes(ctx context.Context, uptimes <-chan struct{}, count int) bool {
+	for i := 0; i < count; i++ {
+		select {
+		case <-uptimes:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
+
+// probeTimeout uses the scrape timeout sent by Prometheus, minus a small
+// margin so the response is written before Prometheus gives up.
+func probeTimeout(r *http.Request) time.Duration {
+	header := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds")
+	if header == "" {
+		return defaultProbeTimeout
+	}
+	seconds, err := strconv.ParseFloat(header, 64)
+	if err != nil || seconds <= 1 {
+		return defaultProbeTimeout
+	}
+	return time.Duration((seconds - 0.5) * float64(time.Second))
+}
+
+// probeURL adds a scheme to targets given as host:port.
+func probeURL(target string, module ModuleConfig) string {
+	if strings.Contains(target, "://") {
+		return target
+	}
+	if module.TLS.Enabled() {
+		return "ssl://" + target
+	}
+	return "tcp://" + target
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// staticCollector exposes a fixed set of metrics.
+type staticCollector []prometheus.Metric
+
+func (c staticCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range c {
+		ch <- m.Desc()
+	}
+}
+
+func (c staticCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range c {
+		ch <- m
+	}
+}
@@ -0,0 +1,87 @@
+package internal
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// closedAddress returns a local address nothing listens on.
+func closedAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	listener.Close()
+	return address
+}
+
+func TestProbeHandler_BadRequests(t *testing.T) {
+	handler := NewProbeHandler(map[string]ModuleConfig{"default": {}})
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/probe", nil))
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/probe?target=127.0.0.1:1883&module=missing", nil))
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/probe?target=%ff:1883", nil))
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+}
+
+func TestProbeHandler_Unreachable(t *testing.T) {
+	handler := NewProbeHandler(nil)
+	handler.SetModules(map[string]ModuleConfig{"default": {Collectors: []string{"clients"}}})
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/probe?target="+closedAddress(t), nil)
+	request.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", "3")
+	handler.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "mosquitto_up{broker=")
+	assert.Contains(t, recorder.Body.String(), "mosquitto_probe_sys_complete")
+}
+
+func TestProbe_Unreachable(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	registry := prometheus.NewRegistry()
+	require.NoError(t, Probe(ctx, BrokerConfig{URL: "tcp://" + closedAddress(t)}, registry))
+
+	count, err := testutil.GatherAndCount(registry, "mosquitto_up", "mosquitto_probe_sys_complete")
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	// Invalid metrics are reported instead of panicking
+	err = Probe(ctx, BrokerConfig{Name: "\xff", URL: "tcp://" + closedAddress(t)}, prometheus.NewRegistry())
+	assert.Error(t, err)
+}
+
+func TestProbeTimeout(t *testing.T) {
+	request := httptest.NewRequest(http.MethodGet, "/probe", nil)
+	assert.Equal(t, defaultProbeTimeout, probeTimeout(request))
+
+	request.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", "15")
+	assert.Equal(t, 14500*time.Millisecond, probeTimeout(request))
+
+	request.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", "invalid")
+	assert.Equal(t, defaultProbeTimeout, probeTimeout(request))
+}
+
+func TestProbeURL(t *testing.T) {
+	assert.Equal(t, "tcp://broker:1883", probeURL("broker:1883", ModuleConfig{}))
+	assert.Equal(t, "ssl://broker:8883", probeURL("broker:8883", ModuleConfig{TLS: TLSConfig{InsecureSkipVerify: true}}))
+	assert.Equal(t, "ws://broker:8080", probeURL("ws://broker:8080", ModuleConfig{}))
+}
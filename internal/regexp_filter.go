@@ -0,0 +1,46 @@
+package internal
+
+import (
+	"regexp"
+)
+
+// RegexpFilter selects the names matching Allow and not matching Deny. Both
+// are regular expressions, an empty one is ignored.
+type RegexpFilter struct {
+	Allow string `yaml:"allow"`
+	Deny  string `yaml:"deny"`
+}
+
+func (f RegexpFilter) validate() error {
+	for _, expr := range []string{f.Allow, f.Deny} {
+		if _, err := regexp.Compile(expr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compile returns the matcher of the expressions, which were validated with
+// the configuration.
+func (f RegexpFilter) compile() regexpMatcher {
+	var matcher regexpMatcher
+	if f.Allow != "" {
+		matcher.allow = regexp.MustCompile(f.Allow)
+	}
+	if f.Deny != "" {
+		matcher.deny = regexp.MustCompile(f.Deny)
+	}
+	return matcher
+}
+
+type regexpMatcher struct {
+	allow *regexp.Regexp
+	deny  *regexp.Regexp
+}
+
+func (m regexpMatcher) matches(name string) bool {
+	if m.allow != nil && !m.allow.MatchString(name) {
+		return false
+	}
+	return m.deny == nil || !m.deny.MatchString(name)
+}
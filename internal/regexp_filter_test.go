@@ -0,0 +1,29 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestRegexpFilter(t *testing.T) {
+	filter := RegexpFilter{Allow: "^sensor-", Deny: "-debug$"}
+	require.NoError(t, filter.validate())
+	matcher := filter.compile()
+	assert.True(t, matcher.matches("sensor-1"))
+	assert.False(t, matcher.matches("sensor-1-debug"))
+	assert.False(t, matcher.matches("gateway"))
+
+	// Empty expressions select everything
+	assert.True(t, RegexpFilter{}.compile().matches("gateway"))
+	assert.Error(t, RegexpFilter{Deny: "("}.validate())
+}
+
+func TestRegexpFilter_YAML(t *testing.T) {
+	// The expressions are set next to the other options of the collector
+	var options ConnectionsOptions
+	require.NoError(t, yaml.Unmarshal([]byte("max_series: 10\nallow: ^sensor-\ndeny: -debug$\n"), &options))
+	assert.Equal(t, ConnectionsOptions{MaxSeries: 10, RegexpFilter: RegexpFilter{Allow: "^sensor-", Deny: "-debug$"}}, options)
+}
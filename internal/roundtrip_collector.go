@@ -0,0 +1,248 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	defaultRoundtripTopic    = "mosquitto_exporter/roundtrip"
+	defaultRoundtripInterval = 15 * time.Second
+	defaultRoundtripTimeout  = 5 * time.Second
+)
+
+var roundtripQoS = []byte{0, 1, 2}
+
+// RoundtripOptions configures the round-trip collector.
+type RoundtripOptions struct {
+	// Topic prefix of the probe messages, one subtopic is used per QoS.
+	Topic string `yaml:"topic"`
+	// Interval between two probes, defaults to 15 seconds.
+	Interval time.Duration `yaml:"interval"`
+	// Timeout after which a probe is considered lost, defaults to 5 seconds.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+func (o RoundtripOptions) validate() error {
+	if strings.ContainsAny(o.Topic, "+#") {
+		return fmt.Errorf("roundtrip.topic must not contain wildcards")
+	}
+	if o.Interval < 0 {
+		return errNegativeDuration("roundtrip.interval")
+	}
+	if o.Timeout < 0 {
+		return errNegativeDuration("roundtrip.timeout")
+	}
+	return nil
+}
+
+// roundtripPayload is the body of a probe message. ID identifies the
+// exporter instance, so several exporters can share the same topic.
+type roundtripPayload struct {
+	ID       string `json:"id"`
+	Sequence uint64 `json:"seq"`
+	Sent     int64  `json:"sent"`
+}
+
+// RoundtripCollector periodically publishes a message at every QoS level and
+// measures the time it takes to receive it back on its own subscription.
+type RoundtripCollector struct {
+	mu       sync.Mutex
+	topic    string
+	interval time.Duration
+	timeout  time.Duration
+	id       string
+	sequence uint64
+	// Sent time of the probes not received yet, per QoS and sequence
+	pending map[byte]map[uint64]time.Time
+	success map[byte]float64
+
+	latency     *prometheus.HistogramVec
+	lost        *prometheus.CounterVec
+	successDesc *prometheus.Desc
+	now         func() time.Time
+	once        sync.Once
+	done        chan struct{}
+}
+
+func init() {
+	registerCollector("roundtrip", false, "Enable the publish/subscribe round-trip probe.", func(labels prometheus.Labels, options CollectorOptions) Collector {
+		return NewRoundtripCollector(labels, options.Roundtrip)
+	})
+}
+
+func NewRoundtripCollector(labels prometheus.Labels, options RoundtripOptions) *RoundtripCollector {
+	id := make([]byte, 8)
+	rand.Read(id)
+	collector := &RoundtripCollector{
+		topic:    options.Topic,
+		interval: options.Interval,
+		timeout:  options.Timeout,
+		id:       hex.EncodeToString(id),
+		pending:  make(map[byte]map[uint64]time.Time, len(roundtripQoS)),
+		success:  make(map[byte]float64, len(roundtripQoS)),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:        "mosquitto_probe_roundtrip_seconds",
+			Help:        "Time between publishing a probe message and receiving it back",
+			ConstLabels: labels,
+			Buckets:     prometheus.ExponentialBuckets(0.0005, 2, 14),
+		}, []string{"qos"}),
+		lost: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "mosquitto_probe_lost_total",
+			Help:        "Total number of probe messages not received back before the timeout",
+			ConstLabels: labels,
+		}, []string{"qos"}),
+		successDesc: prometheus.NewDesc("mosquitto_probe_success", "Whether the last probe message was received back before the timeout", []string{"qos"}, labels),
+		now:         time.Now,
+		done:        make(chan struct{}),
+	}
+	if collector.topic == "" {
+		collector.topic = defaultRoundtripTopic
+	}
+	if collector.interval <= 0 {
+		collector.interval = defaultRoundtripInterval
+	}
+	if collector.timeout <= 0 {
+		collector.timeout = defaultRoundtripTimeout
+	}
+	for _, qos := range roundtripQoS {
+		collector.pending[qos] = make(map[uint64]time.Time)
+	}
+	return collector
+}
+
+func (collector *RoundtripCollector) Describe(ch chan<- *prometheus.Desc) {
+	collector.latency.Describe(ch)
+	collector.lost.Describe(ch)
+	ch <- collector.successDesc
+}
+
+func (collector *RoundtripCollector) Collect(ch chan<- prometheus.Metric) {
+	collector.latency.Collect(ch)
+	collector.lost.Collect(ch)
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	for qos, v := range collector.success {
+		ch <- prometheus.MustNewConstMetric(collector.successDesc, prometheus.GaugeValue, v, strconv.Itoa(int(qos)))
+	}
+}
+
+// Subscribe subscribes to the probe topics and starts probing on the first
+// call. It is safe to call it on every reconnection.
+func (collector *RoundtripCollector) Subscribe(client Client) {
+	topic := collector.topic + "/+"
+	if err := client.Subscribe(topic, 2, collector.messageHandler); err != nil {
+		log.Printf("Failed to subscribe to %s: %v", topic, err)
+	}
+	collector.once.Do(func() {
+		go collector.loop(client)
+	})
+}
+
+// Stop ends the periodic probes.
+func (collector *RoundtripCollector) Stop() {
+	select {
+	case <-collector.done:
+	default:
+		close(collector.done)
+	}
+}
+
+func (collector *RoundtripCollector) loop(client Client) {
+	ticker := time.NewTicker(collector.interval)
+	defer ticker.Stop()
+	for {
+		collector.expire()
+		if client.IsConnectionOpen() {
+			for _, qos := range roundtripQoS {
+				collector.probe(client, qos)
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-collector.done:
+			return
+		}
+	}
+}
+
+func (collector *RoundtripCollector) probe(client Client, qos byte) {
+	collector.mu.Lock()
+	collector.sequence++
+	payload := roundtripPayload{ID: collector.id, Sequence: collector.sequence, Sent: collector.now().UnixNano()}
+	collector.pending[qos][payload.Sequence] = time.Unix(0, payload.Sent)
+	collector.mu.Unlock()
+
+	body, _ := json.Marshal(payload)
+	topic := collector.topic + "/" + strconv.Itoa(int(qos))
+	ctx, cancel := context.WithTimeout(context.Background(), collector.timeout)
+	defer cancel()
+	// A publication still pending after the timeout is counted as lost by
+	// expire.
+	if err := client.Publish(ctx, topic, qos, false, body); err != nil && ctx.Err() == nil {
+		log.Printf("Failed to publish probe to %s: %v", topic, err)
+		collector.mu.Lock()
+		delete(collector.pending[qos], payload.Sequence)
+		collector.success[qos] = 0
+		collector.mu.Unlock()
+		collector.lost.WithLabelValues(strconv.Itoa(int(qos))).Inc()
+	}
+}
+
+// expire counts the probes older than the timeout as lost.
+func (collector *RoundtripCollector) expire() {
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	now := collector.now()
+	for qos, pending := range collector.pending {
+		for sequence, sent := range pending {
+			if now.Sub(sent) < collector.timeout {
+				continue
+			}
+			delete(pending, sequence)
+			collector.success[qos] = 0
+			collector.lost.WithLabelValues(strconv.Itoa(int(qos))).Inc()
+		}
+	}
+}
+
+func (collector *RoundtripCollector) messageHandler(client Client, message Message) {
+	levels := topicLevels(message.Topic())
+	qos, err := strconv.Atoi(levels[len(levels)-1])
+	if err != nil || qos < 0 || qos > 2 {
+		return
+	}
+	var payload roundtripPayload
+	if err := json.Unmarshal(message.Payload(), &payload); err != nil || payload.ID != collector.id {
+		// Probe of another exporter sharing the topic
+		return
+	}
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	sent, ok := collector.pending[byte(qos)][payload.Sequence]
+	if !ok {
+		// Duplicate or already counted as lost
+		return
+	}
+	delete(collector.pending[byte(qos)], payload.Sequence)
+	latency := collector.now().Sub(sent)
+	if latency >= collector.timeout {
+		collector.success[byte(qos)] = 0
+		collector.lost.WithLabelValues(strconv.Itoa(qos)).Inc()
+		return
+	}
+	collector.success[byte(qos)] = 1
+	collector.latency.WithLabelValues(strconv.Itoa(qos)).Observe(latency.Seconds())
+}
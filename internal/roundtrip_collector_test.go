@@ -0,0 +1,115 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRoundtripCollector(t *testing.T) {
+	labels := prometheus.Labels{"broker": "test-broker"}
+	collector := NewRoundtripCollector(labels, RoundtripOptions{})
+
+	assert.NotNil(t, collector)
+	assert.Equal(t, defaultRoundtripTopic, collector.topic)
+	assert.Equal(t, defaultRoundtripInterval, collector.interval)
+	assert.Equal(t, defaultRoundtripTimeout, collector.timeout)
+	assert.Len(t, collector.pending, 3)
+	assert.NotEmpty(t, collector.id)
+}
+
+// roundtripMessage builds the probe message collector would receive back.
+func roundtripMessage(t *testing.T, collector *RoundtripCollector, id string, qos string, sequence uint64) *fakeMessage {
+	payload, err := json.Marshal(roundtripPayload{ID: id, Sequence: sequence, Sent: collector.pending[0][sequence].UnixNano()})
+	require.NoError(t, err)
+	return &fakeMessage{topic: collector.topic + "/" + qos, payload: payload}
+}
+
+func TestRoundtripCollector_MessageHandler(t *testing.T) {
+	labels := prometheus.Labels{"broker": "test-broker"}
+	collector := NewRoundtripCollector(labels, RoundtripOptions{Timeout: time.Second})
+	now := time.Now()
+	collector.now = func() time.Time { return now }
+
+	collector.pending[0][1] = now
+	collector.pending[1][2] = now
+	now = now.Add(20 * time.Millisecond)
+
+	// Messages of other exporters and unknown sequences are ignored
+	collector.messageHandler(nil, roundtripMessage(t, collector, "other", "0", 1))
+	collector.messageHandler(nil, roundtripMessage(t, collector, collector.id, "0", 42))
+	collector.messageHandler(nil, &fakeMessage{topic: collector.topic + "/0", payload: []byte("garbage")})
+	assert.Len(t, collector.pending[0], 1)
+
+	collector.messageHandler(nil, roundtripMessage(t, collector, collector.id, "0", 1))
+	assert.Empty(t, collector.pending[0])
+	assert.Equal(t, float64(1), collector.success[0])
+	assert.Equal(t, 1, testutil.CollectAndCount(collector.latency))
+
+	// Received after the timeout
+	now = now.Add(2 * time.Second)
+	collector.messageHandler(nil, &fakeMessage{
+		topic:   collector.topic + "/1",
+		payload: []byte(`{"id":"` + collector.id + `","seq":2}`),
+	})
+	assert.Equal(t, float64(0), collector.success[1])
+	assert.Equal(t, float64(1), testutil.ToFloat64(collector.lost.WithLabelValues("1")))
+}
+
+func TestRoundtripCollector_Expire(t *testing.T) {
+	labels := prometheus.Labels{"broker": "test-broker"}
+	collector := NewRoundtripCollector(labels, RoundtripOptions{Timeout: time.Second})
+	now := time.Now()
+	collector.now = func() time.Time { return now }
+	registry := registryWith(t, collector)
+
+	collector.pending[2][1] = now
+	collector.pending[2][2] = now.Add(500 * time.Millisecond)
+	now = now.Add(time.Second)
+	collector.expire()
+
+	assert.Len(t, collector.pending[2], 1)
+	assert.Equal(t, []float64{0}, gatherValues(t, registry, "mosquitto_probe_success"))
+	assert.Equal(t, []float64{1}, gatherValues(t, registry, "mosquitto_probe_lost_total"))
+
+	assert.Error(t, RoundtripOptions{Topic: "probe/#"}.validate())
+	assert.Error(t, RoundtripOptions{Timeout: -time.Second}.validate())
+	assert.NoError(t, RoundtripOptions{Topic: "probe"}.validate())
+}
+
+func TestRoundtripCollector_Subscribe(t *testing.T) {
+	labels := prometheus.Labels{"broker": "test-broker"}
+	collector := NewRoundtripCollector(labels, RoundtripOptions{Interval: time.Minute, Timeout: time.Second})
+	client := newFakeClient("exporter")
+	collector.Subscribe(client)
+	defer collector.Stop()
+
+	// The probes are sent right away and come back through the subscription
+	assert.Eventually(t, func() bool {
+		return testutil.CollectAndCount(collector.latency) == 3
+	}, time.Second, 10*time.Millisecond)
+	for _, qos := range []string{"0", "1", "2"} {
+		assert.Len(t, client.publishedOn(collector.topic+"/"+qos), 1)
+	}
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	assert.Equal(t, map[byte]float64{0: 1, 1: 1, 2: 1}, collector.success)
+}
+
+func TestRoundtripCollector_PublishError(t *testing.T) {
+	labels := prometheus.Labels{"broker": "test-broker"}
+	collector := NewRoundtripCollector(labels, RoundtripOptions{Timeout: time.Second})
+	client := newFakeClient("exporter")
+	client.publishErr = errors.New("not authorized")
+
+	collector.probe(client, 1)
+	assert.Empty(t, collector.pending[1])
+	assert.Equal(t, float64(0), collector.success[1])
+	assert.Equal(t, float64(1), testutil.ToFloat64(collector.lost.WithLabelValues("1")))
+}
@@ -0,0 +1,111 @@
+package internal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// selectorStep is either an object key or an array index.
+type selectorStep struct {
+	key   string
+	index int
+}
+
+// selector is a JSONPath-like field selector such as '$.sensors[0].value'.
+// Only child keys and array indices are supported.
+type selector []selectorStep
+
+// parseSelector parses a selector. The leading '$' is optional.
+func parseSelector(expr string) (selector, error) {
+	rest := strings.TrimPrefix(expr, "$")
+	var steps selector
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			key := rest[1 : end+1]
+			if key == "" {
+				return nil, fmt.Errorf("invalid selector %q: empty key", expr)
+			}
+			steps = append(steps, selectorStep{key: key})
+			rest = rest[end+1:]
+		case '[':
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("invalid selector %q: missing ']'", expr)
+			}
+			inner := rest[1:end]
+			if index, err := strconv.Atoi(inner); err == nil && index >= 0 {
+				steps = append(steps, selectorStep{index: index})
+			} else if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+				// Quoted keys, for keys containing dots
+				steps = append(steps, selectorStep{key: inner[1 : len(inner)-1]})
+			} else {
+				return nil, fmt.Errorf("invalid selector %q: bad index %q", expr, inner)
+			}
+			rest = rest[end+1:]
+		default:
+			if len(steps) > 0 || strings.HasPrefix(expr, "$") {
+				return nil, fmt.Errorf("invalid selector %q", expr)
+			}
+			// 'a.b' is accepted as a shorthand for '$.a.b'
+			rest = "." + rest
+		}
+	}
+	return steps, nil
+}
+
+// lookup returns the value selected in a document decoded by encoding/json.
+func (s selector) lookup(document interface{}) (interface{}, bool) {
+	value := document
+	for _, step := range s {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			next, ok := v[step.key]
+			if step.key == "" || !ok {
+				return nil, false
+			}
+			value = next
+		case []interface{}:
+			if step.key != "" || step.index >= len(v) {
+				return nil, false
+			}
+			value = v[step.index]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// selectorNumber converts a selected value to a sample value. Booleans are
+// 0 or 1 and strings are parsed.
+func selectorNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case bool:
+		return boolToFloat(v), true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// selectorString converts a selected value to a label value.
+func selectorString(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	return "", false
+}
@@ -0,0 +1,66 @@
+package internal
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSelector(t *testing.T) {
+	s, err := parseSelector("$.sensors[1].value")
+	require.NoError(t, err)
+	assert.Equal(t, selector{{key: "sensors"}, {index: 1}, {key: "value"}}, s)
+
+	s, err = parseSelector("sensors.temperature")
+	require.NoError(t, err)
+	assert.Equal(t, selector{{key: "sensors"}, {key: "temperature"}}, s)
+
+	s, err = parseSelector(`$['battery.level']`)
+	require.NoError(t, err)
+	assert.Equal(t, selector{{key: "battery.level"}}, s)
+
+	s, err = parseSelector("$")
+	require.NoError(t, err)
+	assert.Empty(t, s)
+
+	for _, expr := range []string{"$.", "$.a..b", "$.a[", "$.a[x]", "$.a[-1]", "$a"} {
+		_, err := parseSelector(expr)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestSelector_Lookup(t *testing.T) {
+	var document interface{}
+	require.NoError(t, json.Unmarshal([]byte(`{"sensors":[{"value":21.5},{"value":"19"}],"online":true,"room":"kitchen"}`), &document))
+
+	for expr, expected := range map[string]float64{
+		"$.sensors[0].value": 21.5,
+		"$.sensors[1].value": 19,
+		"$.online":           1,
+	} {
+		s, err := parseSelector(expr)
+		require.NoError(t, err)
+		value, ok := s.lookup(document)
+		require.True(t, ok, expr)
+		number, ok := selectorNumber(value)
+		assert.True(t, ok, expr)
+		assert.Equal(t, expected, number, expr)
+	}
+
+	s, _ := parseSelector("$.room")
+	value, ok := s.lookup(document)
+	require.True(t, ok)
+	label, ok := selectorString(value)
+	assert.True(t, ok)
+	assert.Equal(t, "kitchen", label)
+	_, ok = selectorNumber(value)
+	assert.False(t, ok)
+
+	for _, expr := range []string{"$.missing", "$.sensors[2]", "$.sensors.value", "$.room.name"} {
+		s, _ := parseSelector(expr)
+		_, ok := s.lookup(document)
+		assert.False(t, ok, expr)
+	}
+}
@@ -4,17 +4,47 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-var (
-	// SubscriptionErrors counts subscription failures per topic and error.
-	SubscriptionErrors = prometheus.NewCounterVec(
-		prometheus.CounterOpts{
-			Name: "mosquitto_subscription_errors_total",
-			Help: "Total number of subscription errors",
-		},
-		[]string{"topic", "error"},
-	)
-)
+// subscriptionErrors counts the failed subscriptions of the collectors of a
+// broker per topic and error.
+type subscriptionErrors struct {
+	errors *prometheus.CounterVec
+}
+
+func newSubscriptionErrors(labels prometheus.Labels) *subscriptionErrors {
+	return &subscriptionErrors{
+		errors: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name:        "mosquitto_subscription_errors_total",
+				Help:        "Total number of subscription errors",
+				ConstLabels: labels,
+			},
+			[]string{"topic", "error"},
+		),
+	}
+}
+
+// client returns a Client counting the failed subscriptions made through it.
+func (s *subscriptionErrors) client(client Client) Client {
+	return countingClient{Client: client, errors: s.errors}
+}
+
+func (s *subscriptionErrors) Describe(ch chan<- *prometheus.Desc) {
+	s.errors.Describe(ch)
+}
+
+func (s *subscriptionErrors) Collect(ch chan<- prometheus.Metric) {
+	s.errors.Collect(ch)
+}
+
+type countingClient struct {
+	Client
+	errors *prometheus.CounterVec
+}
 
-func init() {
-	prometheus.MustRegister(SubscriptionErrors)
-}
\ No newline at end of file
+func (c countingClient) Subscribe(filter string, qos byte, handler MessageHandler) error {
+	err := c.Client.Subscribe(filter, qos, handler)
+	if err != nil {
+		c.errors.WithLabelValues(filter, err.Error()).Inc()
+	}
+	return err
+}
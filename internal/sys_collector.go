@@ -0,0 +1,303 @@
+package internal
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+)
+
+// SysMapping turns the values published on a $SYS topic into a metric.
+type SysMapping struct {
+	// Topic is a topic filter, its wildcards can label the metric.
+	Topic  string `yaml:"topic"`
+	Metric string `yaml:"metric"`
+	// Type is gauge (the default) or counter.
+	Type string `yaml:"type"`
+	Help string `yaml:"help"`
+	// Parser reads the payload: float (the default), integer, seconds for
+	// 'N seconds' or version for 'mosquitto version X', exported as 1 with
+	// the version in a version label.
+	Parser string `yaml:"parser"`
+	// Labels maps label names to 'topic:N', the N-th wildcard of the topic
+	// (starting at 1).
+	Labels map[string]string `yaml:"labels"`
+}
+
+// SysTable is a set of mappings of the $SYS tree read by one collector.
+type SysTable struct {
+	// Filters are subscribed to receive the values of the mappings, the
+	// topics of the mappings themselves when empty.
+	Filters  []string
+	Mappings []SysMapping
+}
+
+// filters returns the topic filters to subscribe to.
+func (t SysTable) filters() []string {
+	if len(t.Filters) > 0 {
+		return t.Filters
+	}
+	var filters []string
+	for _, mapping := range t.Mappings {
+		if !slices.Contains(filters, mapping.Topic) {
+			filters = append(filters, mapping.Topic)
+		}
+	}
+	return filters
+}
+
+// sysParsers read the value of a payload, returning the label value of the
+// parsers exporting their result as a label.
+var sysParsers = map[string]func(payload string) (float64, string, error){
+	"float": func(payload string) (float64, string, error) {
+		value, err := strconv.ParseFloat(strings.TrimSpace(payload), 64)
+		return value, "", err
+	},
+	// Heap sizes are 'XXX' or 'XXX bytes' depending on the broker version
+	"integer": func(payload string) (float64, string, error) {
+		value, err := strconv.Atoi(firstField(payload))
+		return float64(value), "", err
+	},
+	"seconds": func(payload string) (float64, string, error) {
+		value, err := strconv.Atoi(firstField(payload))
+		return float64(value), "", err
+	},
+	"version": func(payload string) (float64, string, error) {
+		fields := strings.Fields(payload)
+		if len(fields) == 0 {
+			return 0, "", fmt.Errorf("empty version")
+		}
+		return 1, fields[len(fields)-1], nil
+	},
+}
+
+func firstField(payload string) string {
+	fields := strings.Fields(payload)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+type sysMapping struct {
+	name      string
+	topic     string
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+	parse     func(payload string) (float64, string, error)
+	// wildcards are the wildcards of the topic giving the label values, in
+	// the order of the label names.
+	wildcards  []int
+	labelNames []string
+	// version is set when the parsed version is the last label value.
+	version bool
+}
+
+func compileSysMapping(mapping SysMapping, labels prometheus.Labels) (*sysMapping, error) {
+	if err := validTopicFilter(mapping.Topic); err != nil {
+		return nil, err
+	}
+	if !model.LegacyValidation.IsValidMetricName(mapping.Metric) {
+		return nil, fmt.Errorf("invalid metric name %q", mapping.Metric)
+	}
+	compiled := &sysMapping{name: mapping.Metric, topic: mapping.Topic}
+	switch mapping.Type {
+	case "", "gauge":
+		compiled.valueType = prometheus.GaugeValue
+	case "counter":
+		compiled.valueType = prometheus.CounterValue
+	default:
+		return nil, fmt.Errorf("metric %s: unknown type %q", mapping.Metric, mapping.Type)
+	}
+	parser := mapping.Parser
+	if parser == "" {
+		parser = "float"
+	}
+	parse, ok := sysParsers[parser]
+	if !ok {
+		return nil, fmt.Errorf("metric %s: unknown parser %q", mapping.Metric, mapping.Parser)
+	}
+	compiled.parse = parse
+	compiled.version = parser == "version"
+
+	wildcards := filterWildcards(mapping.Topic)
+	used := make(map[int]bool, wildcards)
+	for _, name := range sortedLabelNames(mapping.Labels) {
+		if !model.LegacyValidation.IsValidLabelName(name) || name == "broker" || (compiled.version && name == "version") {
+			return nil, fmt.Errorf("metric %s: invalid label name %q", mapping.Metric, name)
+		}
+		source := mapping.Labels[name]
+		n, err := strconv.Atoi(strings.TrimPrefix(source, "topic:"))
+		if !strings.HasPrefix(source, "topic:") || err != nil || n < 1 || n > wildcards {
+			return nil, fmt.Errorf("metric %s: label %s must be 'topic:N', N being a wildcard of %q", mapping.Metric, name, mapping.Topic)
+		}
+		used[n] = true
+		compiled.wildcards = append(compiled.wildcards, n)
+		compiled.labelNames = append(compiled.labelNames, name)
+	}
+	// Topics differing by an unused wildcard would be the same series
+	if len(used) != wildcards {
+		return nil, fmt.Errorf("metric %s: every wildcard of %q must be a label", mapping.Metric, mapping.Topic)
+	}
+	variableLabels := compiled.labelNames
+	if compiled.version {
+		variableLabels = append(variableLabels[:len(variableLabels):len(variableLabels)], "version")
+	}
+	help := mapping.Help
+	if help == "" {
+		help = fmt.Sprintf("Value published on %s", mapping.Topic)
+	}
+	compiled.desc = prometheus.NewDesc(mapping.Metric, help, variableLabels, labels)
+	return compiled, nil
+}
+
+// labelValues returns the label values of the value published on topic.
+func (m *sysMapping) labelValues(topic string, parsed string) []string {
+	var values []string
+	if len(m.wildcards) > 0 {
+		wildcards := topicWildcards(m.topic, topic)
+		for _, n := range m.wildcards {
+			values = append(values, wildcards[n-1])
+		}
+	}
+	if m.version {
+		values = append(values, parsed)
+	}
+	return values
+}
+
+// ageName names a series in the age metric of the SysTracker: the metric
+// name, followed by the topic labels if it has some.
+func (m *sysMapping) ageName(labelValues []string) string {
+	if len(m.labelNames) == 0 {
+		return m.name
+	}
+	pairs := make([]string, len(m.labelNames))
+	for i, name := range m.labelNames {
+		pairs[i] = fmt.Sprintf("%s=%q", name, labelValues[i])
+	}
+	return m.name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+// sysValue is the last value received on a topic.
+type sysValue struct {
+	mapping     *sysMapping
+	value       float64
+	labelValues []string
+	updated     time.Time
+}
+
+// SysCollector exports the values of the $SYS tree described by a SysTable.
+// Values are only exported once received, and only while the SysTracker
+// considers them fresh.
+type SysCollector struct {
+	mu       sync.RWMutex
+	Values   map[string]*sysValue
+	filters  []string
+	mappings []*sysMapping
+	sys      *SysTracker
+	// observe is called with every value before it is stored, outside of
+	// the lock.
+	observe func(topic string, value *sysValue)
+}
+
+// NewSysCollector creates a collector for table. The mappings are expected
+// to be valid, the invalid ones are logged and skipped.
+func NewSysCollector(labels prometheus.Labels, table SysTable) *SysCollector {
+	collector := &SysCollector{
+		mu:      sync.RWMutex{},
+		Values:  make(map[string]*sysValue, len(table.Mappings)),
+		filters: table.filters(),
+		sys:     NewSysTracker(labels, SysOptions{}),
+	}
+	for _, mapping := range table.Mappings {
+		compiled, err := compileSysMapping(mapping, labels)
+		if err != nil {
+			log.Printf("Ignoring $SYS mapping: %v", err)
+			continue
+		}
+		collector.mappings = append(collector.mappings, compiled)
+	}
+	return collector
+}
+
+func (collector *SysCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, mapping := range collector.mappings {
+		ch <- mapping.desc
+	}
+}
+
+func (collector *SysCollector) Collect(ch chan<- prometheus.Metric) {
+	collector.mu.RLock()
+	defer collector.mu.RUnlock()
+	for _, v := range collector.Values {
+		if collector.sys.fresh(v.updated) {
+			ch <- prometheus.MustNewConstMetric(v.mapping.desc, v.mapping.valueType, v.value, v.labelValues...)
+		}
+	}
+}
+
+func (collector *SysCollector) setSysTracker(tracker *SysTracker) {
+	collector.sys = tracker
+	tracker.track(collector)
+}
+
+func (collector *SysCollector) sysUpdates(f func(name string, updated time.Time)) {
+	collector.mu.RLock()
+	defer collector.mu.RUnlock()
+	for _, v := range collector.Values {
+		f(v.mapping.ageName(v.labelValues), v.updated)
+	}
+}
+
+func (collector *SysCollector) Subscribe(client Client) {
+	for _, filter := range collector.filters {
+		if err := client.Subscribe(filter, 0, collector.sysHandler); err != nil {
+			log.Printf("Failed to subscribe to %s: %v", filter, err)
+		}
+	}
+}
+
+// brokerRestarted forgets the values published by the broker before it
+// restarted.
+func (collector *SysCollector) brokerRestarted() {
+	collector.mu.Lock()
+	clear(collector.Values)
+	collector.mu.Unlock()
+}
+
+func (collector *SysCollector) sysHandler(client Client, message Message) {
+	topic := message.Topic()
+	var mapping *sysMapping
+	for _, m := range collector.mappings {
+		if topicMatches(m.topic, topic) {
+			mapping = m
+			break
+		}
+	}
+	if mapping == nil {
+		return
+	}
+	value, parsed, err := mapping.parse(string(message.Payload()))
+	if err != nil {
+		return
+	}
+	v := &sysValue{
+		mapping:     mapping,
+		value:       value,
+		labelValues: mapping.labelValues(topic, parsed),
+	}
+	if collector.observe != nil {
+		collector.observe(topic, v)
+	}
+	v.updated = collector.sys.received()
+	collector.mu.Lock()
+	collector.Values[topic] = v
+	collector.mu.Unlock()
+}
@@ -0,0 +1,86 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSysParsers(t *testing.T) {
+	testCases := []struct {
+		parser  string
+		payload string
+		value   float64
+		label   string
+	}{
+		{"float", " 0.25\n", 0.25, ""},
+		{"integer", "42", 42, ""},
+		{"integer", "60000 bytes", 60000, ""},
+		{"seconds", "12345 seconds", 12345, ""},
+		{"version", "mosquitto version 2.0.18", 1, "2.0.18"},
+	}
+	for _, tc := range testCases {
+		value, label, err := sysParsers[tc.parser](tc.payload)
+		require.NoError(t, err, tc.payload)
+		assert.Equal(t, tc.value, value, tc.payload)
+		assert.Equal(t, tc.label, label, tc.payload)
+	}
+
+	_, _, err := sysParsers["integer"]("1.5")
+	assert.Error(t, err)
+	_, _, err = sysParsers["version"]("")
+	assert.Error(t, err)
+}
+
+func TestSysCollector_Mappings(t *testing.T) {
+	labels := prometheus.Labels{"broker": "test-broker"}
+	collector := NewSysCollector(labels, SysTable{
+		Mappings: []SysMapping{
+			{Topic: "$SYS/broker/packets/+/1min", Metric: "mosquitto_packets_load1", Labels: map[string]string{"direction": "topic:1"}},
+			{Topic: "$SYS/broker/plugin/+/+", Metric: "mosquitto_plugin_value", Type: "counter", Parser: "integer", Labels: map[string]string{"plugin": "topic:1", "value": "topic:2"}},
+		},
+	})
+	client := newFakeClient("exporter")
+	collector.Subscribe(client)
+	registry := registryWith(t, collector)
+
+	client.publish("$SYS/broker/packets/received/1min", "12.5")
+	client.publish("$SYS/broker/packets/sent/1min", "10")
+	client.publish("$SYS/broker/plugin/dynsec/count", "3")
+	client.publish("$SYS/broker/plugin/dynsec/count", "not a number")
+	client.publish("$SYS/broker/packets/received/5min", "1")
+
+	assert.Equal(t, []float64{12.5, 10}, gatherValues(t, registry, "mosquitto_packets_load1"))
+	// Invalid payloads are ignored
+	assert.Equal(t, []float64{3}, gatherValues(t, registry, "mosquitto_plugin_value"))
+	assert.Len(t, collector.Values, 3)
+	v := collector.Values["$SYS/broker/plugin/dynsec/count"]
+	assert.Equal(t, []string{"dynsec", "count"}, v.labelValues)
+	assert.Equal(t, `mosquitto_plugin_value{plugin="dynsec",value="count"}`, v.mapping.ageName(v.labelValues))
+
+	collector.brokerRestarted()
+	assert.Empty(t, gatherValues(t, registry, "mosquitto_packets_load1"))
+}
+
+func TestSysOptions_Mappings(t *testing.T) {
+	valid := SysMapping{Topic: "$SYS/broker/packets/received", Metric: "mosquitto_packets_received_total", Type: "counter"}
+	assert.NoError(t, SysOptions{Mappings: []SysMapping{valid}}.validate())
+
+	for _, mapping := range []SysMapping{
+		{Topic: "$SYS/broker/packets/+", Metric: "mosquitto_packets"},
+		{Topic: "$SYS/broker/packets/+", Metric: "mosquitto_packets", Labels: map[string]string{"direction": "topic:2"}},
+		{Topic: "$SYS/broker/packets/+", Metric: "mosquitto_packets", Labels: map[string]string{"broker": "topic:1"}},
+		{Topic: "$SYS/broker/version", Metric: "mosquitto_version", Parser: "version", Labels: map[string]string{"version": "topic:1"}},
+		{Topic: "$SYS/broker/packets/received", Metric: "mosquitto-packets"},
+		{Topic: "$SYS/broker/packets/received", Metric: "mosquitto_packets", Type: "histogram"},
+		{Topic: "$SYS/broker/packets/received", Metric: "mosquitto_packets", Parser: "hex"},
+		{Topic: "$SYS/broker/packets/#/received", Metric: "mosquitto_packets"},
+		// Already exported by a built-in collector
+		{Topic: "$SYS/broker/clients/connected", Metric: "mosquitto_connected_clients_count"},
+	} {
+		assert.Error(t, SysOptions{Mappings: []SysMapping{mapping}}.validate(), mapping.Metric)
+	}
+	assert.Error(t, SysOptions{Mappings: []SysMapping{valid, valid}}.validate())
+}
@@ -0,0 +1,183 @@
+package internal
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Note the space in the topic published by the broker.
+const retainedMessagesTopic = "$SYS/broker/retained messages/#"
+
+const (
+	uptimeTopic  = "$SYS/broker/uptime"
+	versionTopic = "$SYS/broker/version"
+)
+
+// defaultTable is read by the default collector, which is always enabled.
+var defaultTable = SysTable{
+	Mappings: []SysMapping{
+		{Topic: uptimeTopic, Metric: "mosquitto_uptime_seconds", Type: "counter", Help: "Seconds since the broker was started", Parser: "seconds"},
+		{Topic: versionTopic, Metric: "mosquitto_version_info", Help: "Mosquitto version", Parser: "version"},
+		{Topic: "$SYS/broker/subscriptions/count", Metric: "mosquitto_subscriptions_total", Help: "Number of active subscriptions", Parser: "integer"},
+		{Topic: "$SYS/broker/shared_subscriptions/count", Metric: "mosquitto_shared_subscriptions_total", Help: "Number of active shared subscriptions", Parser: "integer"},
+	},
+}
+
+// sysTables are the tables of the $SYS collectors that can be enabled, by
+// collector name. The load table depends on its options and is built by
+// loadTable.
+var sysTables = map[string]SysTable{
+	"clients": {
+		Filters: []string{"$SYS/broker/clients/#"},
+		Mappings: []SysMapping{
+			{Topic: "$SYS/broker/clients/active", Metric: "mosquitto_active_clients_count", Help: "Number of active clients", Parser: "integer"},
+			{Topic: "$SYS/broker/clients/connected", Metric: "mosquitto_connected_clients_count", Help: "Number of connected clients", Parser: "integer"},
+			{Topic: "$SYS/broker/clients/disconnected", Metric: "mosquitto_disconnected_clients_count", Help: "Number of disconnected clients", Parser: "integer"},
+			{Topic: "$SYS/broker/clients/expired", Metric: "mosquitto_expired_clients_count", Help: "Number of expired clients", Parser: "integer"},
+			{Topic: "$SYS/broker/clients/inactive", Metric: "mosquitto_inactive_clients_count", Help: "Number of inactive clients", Parser: "integer"},
+			{Topic: "$SYS/broker/clients/maximum", Metric: "mosquitto_maximum_clients_count", Help: "Maximum number of simultaneously connected clients", Parser: "integer"},
+			{Topic: "$SYS/broker/clients/total", Metric: "mosquitto_total_clients_count", Help: "Total number of clients", Parser: "integer"},
+		},
+	},
+	"messages": {
+		Filters: []string{"$SYS/broker/messages/#", "$SYS/broker/store/messages/#"},
+		Mappings: []SysMapping{
+			{Topic: "$SYS/broker/messages/received", Metric: "mosquitto_received_messages_count", Type: "counter", Help: "Number of received messages", Parser: "integer"},
+			{Topic: "$SYS/broker/messages/sent", Metric: "mosquitto_sent_messages_count", Type: "counter", Help: "Number of sent messages", Parser: "integer"},
+			{Topic: "$SYS/broker/store/messages/count", Metric: "mosquitto_stored_messages_count", Help: "Number of stored messages", Parser: "integer"},
+			{Topic: "$SYS/broker/store/messages/bytes", Metric: "mosquitto_stored_messages_bytes", Help: "Stored messages size in bytse", Parser: "integer"},
+			{Topic: "$SYS/broker/messages/inflight", Metric: "mosquitto_inflight_messages_gauge", Help: "Number of inflight messages", Parser: "integer"},
+		},
+	},
+	"heap": {
+		Filters: []string{"$SYS/broker/heap/#"},
+		Mappings: []SysMapping{
+			{Topic: "$SYS/broker/heap/current", Metric: "mosquitto_heap_current_bytes", Help: "Current size of the heap memory in use by the broker", Parser: "integer"},
+			{Topic: "$SYS/broker/heap/maximum", Metric: "mosquitto_heap_maximum_bytes", Help: "Largest size of the heap memory used by the broker", Parser: "integer"},
+		},
+	},
+	// The cumulative byte and publish counters of the broker, complementing
+	// the moving averages of the load table.
+	"traffic": {
+		Filters: []string{"$SYS/broker/bytes/#", "$SYS/broker/publish/#"},
+		Mappings: []SysMapping{
+			{Topic: "$SYS/broker/bytes/received", Metric: "mosquitto_bytes_received_total", Type: "counter", Help: "Total number of bytes received by the broker"},
+			{Topic: "$SYS/broker/bytes/sent", Metric: "mosquitto_bytes_sent_total", Type: "counter", Help: "Total number of bytes sent by the broker"},
+			{Topic: "$SYS/broker/publish/messages/received", Metric: "mosquitto_publish_messages_received_total", Type: "counter", Help: "Total number of publish messages received by the broker"},
+			{Topic: "$SYS/broker/publish/messages/sent", Metric: "mosquitto_publish_messages_sent_total", Type: "counter", Help: "Total number of publish messages sent by the broker"},
+			{Topic: "$SYS/broker/publish/messages/dropped", Metric: "mosquitto_publish_messages_dropped_total", Type: "counter", Help: "Total number of publish messages dropped by the broker"},
+			{Topic: "$SYS/broker/publish/bytes/received", Metric: "mosquitto_publish_bytes_received_total", Type: "counter", Help: "Total number of publish payload bytes received by the broker"},
+			{Topic: "$SYS/broker/publish/bytes/sent", Metric: "mosquitto_publish_bytes_sent_total", Type: "counter", Help: "Total number of publish payload bytes sent by the broker"},
+		},
+	},
+	"retained": {
+		Filters: []string{retainedMessagesTopic},
+		Mappings: []SysMapping{
+			{Topic: "$SYS/broker/retained messages/count", Metric: "mosquitto_retained_messages", Help: "Number of retained messages stored by the broker", Parser: "integer"},
+		},
+	},
+}
+
+func init() {
+	for name, help := range map[string]string{
+		"clients":  "Enable the clients collector.",
+		"messages": "Enable the messages collector.",
+		"heap":     "Enable the heap collector.",
+		"traffic":  "Enable the traffic collector.",
+		"retained": "Enable the retained messages collector.",
+	} {
+		table := sysTables[name]
+		registerCollector(name, false, help, func(labels prometheus.Labels, options CollectorOptions) Collector {
+			return NewSysCollector(labels, table)
+		})
+	}
+	registerCollector("load", false, "Enable the load collector.", func(labels prometheus.Labels, options CollectorOptions) Collector {
+		return NewSysCollector(labels, loadTable(options.Load))
+	})
+}
+
+// loadIntervals are the moving average windows published by the broker, and
+// the suffix of their metrics.
+var loadIntervals = [3]struct{ name, suffix string }{
+	{"1min", "_load1"},
+	{"5min", "_load5"},
+	{"15min", "_load15"},
+}
+
+// loadValues are the moving averages published under $SYS/broker/load.
+var loadValues = []struct{ topic, metric, help string }{
+	{"connections", "mosquitto_connections", "The moving average of the number of connections opened to the broker"},
+	{"sockets", "mosquitto_sockets", "The moving average of the number of socket connections opened to the broker"},
+	{"bytes/received", "mosquitto_bytes_received", "The moving average of the number of bytes received by the broker"},
+	{"bytes/sent", "mosquitto_bytes_sent", "The moving average of the number of bytes sent by the broker"},
+	{"messages/received", "mosquitto_messages_received", "The moving average of the number of messages received by the broker"},
+	{"messages/sent", "mosquitto_messages_sent", "The moving average of the number of messages sent by the broker"},
+	{"publish/received", "mosquitto_publish_received", "The moving average of the number of publish messages received by the broker"},
+	{"publish/sent", "mosquitto_publish_sent", "The moving average of the number of publish messages sent by the broker"},
+	{"publish/dropped", "mosquitto_publish_dropped", "The moving average of the number of publish messages dropped by the broker"},
+}
+
+// LoadOptions configures the load collector.
+type LoadOptions struct {
+	// Intervals restricts the exported moving averages to a subset of
+	// 1min, 5min and 15min. All of them are exported when empty.
+	Intervals []string `yaml:"intervals"`
+}
+
+func (o LoadOptions) validate() error {
+	for _, interval := range o.Intervals {
+		if loadIntervalIndex(interval) < 0 {
+			return fmt.Errorf("unknown load interval %q", interval)
+		}
+	}
+	return nil
+}
+
+func loadIntervalIndex(interval string) int {
+	for i, load := range loadIntervals {
+		if load.name == interval {
+			return i
+		}
+	}
+	return -1
+}
+
+// loadTable returns the table of the load collector, restricted to the
+// intervals selected in options.
+func loadTable(options LoadOptions) SysTable {
+	table := SysTable{Filters: []string{"$SYS/broker/load/#"}}
+	for _, load := range loadIntervals {
+		if len(options.Intervals) > 0 && !slices.Contains(options.Intervals, load.name) {
+			continue
+		}
+		for _, value := range loadValues {
+			table.Mappings = append(table.Mappings, SysMapping{
+				Topic:  "$SYS/broker/load/" + value.topic + "/" + load.name,
+				Metric: value.metric + load.suffix,
+				Help:   value.help,
+			})
+		}
+	}
+	return table
+}
+
+// builtinSysTables returns the tables of all the $SYS collectors.
+func builtinSysTables() []SysTable {
+	tables := []SysTable{defaultTable, loadTable(LoadOptions{})}
+	for _, table := range sysTables {
+		tables = append(tables, table)
+	}
+	return tables
+}
+
+// builtinSysFilters returns the filters subscribed by all the $SYS
+// collectors.
+func builtinSysFilters() []string {
+	var filters []string
+	for _, table := range builtinSysTables() {
+		filters = append(filters, table.filters()...)
+	}
+	return filters
+}
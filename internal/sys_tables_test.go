@@ -0,0 +1,122 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/qaoru/mosquitto_exporter/internal/mqtttest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSysTables(t *testing.T) {
+	labels := prometheus.Labels{"broker": "test-broker"}
+
+	testCases := []struct {
+		table   string
+		topic   string
+		payload string
+		metric  string
+		value   float64
+	}{
+		{"clients", "$SYS/broker/clients/active", "10", "mosquitto_active_clients_count", 10},
+		{"clients", "$SYS/broker/clients/connected", "8", "mosquitto_connected_clients_count", 8},
+		{"clients", "$SYS/broker/clients/disconnected", "2", "mosquitto_disconnected_clients_count", 2},
+		{"clients", "$SYS/broker/clients/expired", "1", "mosquitto_expired_clients_count", 1},
+		{"clients", "$SYS/broker/clients/inactive", "3", "mosquitto_inactive_clients_count", 3},
+		{"clients", "$SYS/broker/clients/maximum", "15", "mosquitto_maximum_clients_count", 15},
+		{"clients", "$SYS/broker/clients/total", "20", "mosquitto_total_clients_count", 20},
+		{"messages", "$SYS/broker/messages/received", "100", "mosquitto_received_messages_count", 100},
+		{"messages", "$SYS/broker/messages/sent", "95", "mosquitto_sent_messages_count", 95},
+		{"messages", "$SYS/broker/messages/inflight", "3", "mosquitto_inflight_messages_gauge", 3},
+		{"messages", "$SYS/broker/store/messages/count", "5", "mosquitto_stored_messages_count", 5},
+		{"messages", "$SYS/broker/store/messages/bytes", "1024", "mosquitto_stored_messages_bytes", 1024},
+		{"heap", "$SYS/broker/heap/current", "52496", "mosquitto_heap_current_bytes", 52496},
+		{"heap", "$SYS/broker/heap/maximum", "78112", "mosquitto_heap_maximum_bytes", 78112},
+		{"heap", "$SYS/broker/heap/current", "60000 bytes", "mosquitto_heap_current_bytes", 60000},
+		{"traffic", "$SYS/broker/bytes/received", "123456", "mosquitto_bytes_received_total", 123456},
+		{"traffic", "$SYS/broker/bytes/sent", "654321", "mosquitto_bytes_sent_total", 654321},
+		{"traffic", "$SYS/broker/publish/messages/received", "42", "mosquitto_publish_messages_received_total", 42},
+		{"traffic", "$SYS/broker/publish/messages/sent", "84", "mosquitto_publish_messages_sent_total", 84},
+		{"traffic", "$SYS/broker/publish/messages/dropped", "2", "mosquitto_publish_messages_dropped_total", 2},
+		{"traffic", "$SYS/broker/publish/bytes/received", "4096", "mosquitto_publish_bytes_received_total", 4096},
+		{"traffic", "$SYS/broker/publish/bytes/sent", "8192000000", "mosquitto_publish_bytes_sent_total", 8192000000},
+		{"retained", "$SYS/broker/retained messages/count", "1337", "mosquitto_retained_messages", 1337},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.topic, func(t *testing.T) {
+			collector := NewSysCollector(labels, sysTables[tc.table])
+			client := newFakeClient("exporter")
+			collector.Subscribe(client)
+			registry := registryWith(t, collector)
+
+			client.publish(tc.topic, tc.payload)
+			assert.Equal(t, []float64{tc.value}, gatherValues(t, registry, tc.metric))
+		})
+	}
+}
+
+func TestSysTables_Describe(t *testing.T) {
+	labels := prometheus.Labels{"broker": "test-broker"}
+	for name, count := range map[string]int{"clients": 7, "messages": 5, "heap": 2, "traffic": 7, "retained": 1} {
+		collector := NewSysCollector(labels, sysTables[name])
+
+		descriptions := make(chan *prometheus.Desc)
+		go func() {
+			collector.Describe(descriptions)
+			close(descriptions)
+		}()
+
+		n := 0
+		for range descriptions {
+			n++
+		}
+		assert.Equal(t, count, n, name)
+	}
+}
+
+func TestLoadTable(t *testing.T) {
+	labels := prometheus.Labels{"broker": "test-broker"}
+	collector := NewSysCollector(labels, loadTable(LoadOptions{}))
+	client := newFakeClient("exporter")
+	collector.Subscribe(client)
+	registry := registryWith(t, collector)
+
+	client.publish("$SYS/broker/load/connections/1min", "1.5")
+	client.publish("$SYS/broker/load/bytes/received/5min", "2048.0")
+	client.publish("$SYS/broker/load/messages/sent/15min", "128.0")
+
+	assert.Equal(t, []float64{1.5}, gatherValues(t, registry, "mosquitto_connections_load1"))
+	assert.Equal(t, []float64{2048}, gatherValues(t, registry, "mosquitto_bytes_received_load5"))
+	assert.Equal(t, []float64{128}, gatherValues(t, registry, "mosquitto_messages_sent_load15"))
+}
+
+func TestLoadTable_Intervals(t *testing.T) {
+	labels := prometheus.Labels{"broker": "test-broker"}
+	collector := NewSysCollector(labels, loadTable(LoadOptions{Intervals: []string{"1min", "15min"}}))
+	client := newFakeClient("exporter")
+	collector.Subscribe(client)
+	for topic, payload := range mqtttest.SysTree(100) {
+		if strings.HasPrefix(topic, "$SYS/broker/load/") {
+			client.publish(topic, payload)
+		}
+	}
+
+	metrics := make(chan prometheus.Metric)
+	go func() {
+		collector.Collect(metrics)
+		close(metrics)
+	}()
+
+	count := 0
+	for range metrics {
+		count++
+	}
+
+	// 9 metrics * 2 load averages each = 18 metrics
+	assert.Equal(t, 18, count)
+
+	assert.NoError(t, LoadOptions{Intervals: []string{"5min"}}.validate())
+	assert.Error(t, LoadOptions{Intervals: []string{"1h"}}.validate())
+}
@@ -0,0 +1,158 @@
+package internal
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SysOptions configures when the values of the $SYS tree are considered
+// stale.
+type SysOptions struct {
+	// StaleIntervals is the number of $SYS intervals after which a value
+	// that was not published again is no longer exported, 0 keeps the
+	// values that were received once. Mosquitto only publishes the values
+	// that changed, so quiet brokers need a large enough number.
+	StaleIntervals int `yaml:"stale_intervals"`
+	// Mappings export more $SYS values, in addition to the built-in ones.
+	Mappings []SysMapping `yaml:"mappings"`
+}
+
+func (o SysOptions) validate() error {
+	if o.StaleIntervals < 0 {
+		return fmt.Errorf("sys.stale_intervals must not be negative")
+	}
+	metrics := make(map[string]bool, len(o.Mappings))
+	for _, table := range builtinSysTables() {
+		for _, mapping := range table.Mappings {
+			metrics[mapping.Metric] = true
+		}
+	}
+	for _, mapping := range o.Mappings {
+		if _, err := compileSysMapping(mapping, nil); err != nil {
+			return fmt.Errorf("sys.mappings: %w", err)
+		}
+		if metrics[mapping.Metric] {
+			return fmt.Errorf("sys.mappings: metric %q is already defined", mapping.Metric)
+		}
+		metrics[mapping.Metric] = true
+	}
+	return nil
+}
+
+// sysSource is a collector whose $SYS values are tracked by a SysTracker. It
+// calls f with the name of every value it received and the time it was last
+// received.
+type sysSource interface {
+	sysUpdates(f func(name string, updated time.Time))
+}
+
+// SysTracker follows the interval at which the broker publishes its $SYS
+// tree, measured from its uptime, and decides whether the values received by
+// the $SYS collectors are recent enough to be exported.
+type SysTracker struct {
+	mu           sync.RWMutex
+	options      SysOptions
+	interval     time.Duration
+	uptime       float64
+	lastReceived time.Time
+	sources      []sysSource
+	descriptions map[string]metric
+	now          func() time.Time
+}
+
+func NewSysTracker(labels prometheus.Labels, options SysOptions) *SysTracker {
+	return &SysTracker{
+		mu:      sync.RWMutex{},
+		options: options,
+		descriptions: map[string]metric{
+			"age": {
+				desc:      prometheus.NewDesc("mosquitto_sys_value_age_seconds", "Seconds since a $SYS value was last received", []string{"metric"}, labels),
+				valueType: prometheus.GaugeValue,
+			},
+			"received": {
+				desc:      prometheus.NewDesc("mosquitto_sys_data_received", "Whether $SYS values were received from the broker and are not stale", nil, labels),
+				valueType: prometheus.GaugeValue,
+			},
+		},
+		now: time.Now,
+	}
+}
+
+func (tracker *SysTracker) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range tracker.descriptions {
+		ch <- desc.desc
+	}
+}
+
+func (tracker *SysTracker) Collect(ch chan<- prometheus.Metric) {
+	tracker.mu.RLock()
+	sources := tracker.sources
+	lastReceived := tracker.lastReceived
+	tracker.mu.RUnlock()
+
+	now := tracker.now()
+	age := tracker.descriptions["age"]
+	for _, source := range sources {
+		source.sysUpdates(func(name string, updated time.Time) {
+			ch <- prometheus.MustNewConstMetric(age.desc, age.valueType, now.Sub(updated).Seconds(), name)
+		})
+	}
+	received := tracker.descriptions["received"]
+	ch <- prometheus.MustNewConstMetric(received.desc, received.valueType, boolToFloat(tracker.fresh(lastReceived)))
+}
+
+// track makes the ages of the values of source part of the metrics of the
+// tracker.
+func (tracker *SysTracker) track(source sysSource) {
+	tracker.mu.Lock()
+	tracker.sources = append(tracker.sources, source)
+	tracker.mu.Unlock()
+}
+
+// received records that a $SYS value was received and returns the time it
+// was received.
+func (tracker *SysTracker) received() time.Time {
+	now := tracker.now()
+	tracker.mu.Lock()
+	tracker.lastReceived = now
+	tracker.mu.Unlock()
+	return now
+}
+
+// observeUptime measures the $SYS interval, the broker adding it to its
+// uptime every time it publishes it. Retained uptimes delivered again on
+// subscription and broker restarts are ignored.
+func (tracker *SysTracker) observeUptime(uptime float64) {
+	tracker.received()
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+	if tracker.uptime > 0 && uptime > tracker.uptime {
+		tracker.interval = time.Duration((uptime - tracker.uptime) * float64(time.Second))
+	}
+	tracker.uptime = uptime
+}
+
+// Interval returns the observed $SYS interval, 0 until the broker published
+// its uptime twice.
+func (tracker *SysTracker) Interval() time.Duration {
+	tracker.mu.RLock()
+	defer tracker.mu.RUnlock()
+	return tracker.interval
+}
+
+// fresh reports whether a value last received at updated is to be exported:
+// it was received at all and, when StaleIntervals is set and the interval is
+// known, not more than StaleIntervals intervals ago.
+func (tracker *SysTracker) fresh(updated time.Time) bool {
+	if updated.IsZero() {
+		return false
+	}
+	interval := tracker.Interval()
+	if tracker.options.StaleIntervals == 0 || interval == 0 {
+		return true
+	}
+	return tracker.now().Sub(updated) <= time.Duration(tracker.options.StaleIntervals)*interval
+}
@@ -0,0 +1,90 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSysTracker_Interval(t *testing.T) {
+	tracker := NewSysTracker(prometheus.Labels{"broker": "test-broker"}, SysOptions{})
+
+	tracker.observeUptime(100)
+	// Retained uptime delivered again on subscription
+	tracker.observeUptime(100)
+	assert.Zero(t, tracker.Interval())
+
+	tracker.observeUptime(110)
+	assert.Equal(t, 10*time.Second, tracker.Interval())
+
+	// Broker restart
+	tracker.observeUptime(5)
+	assert.Equal(t, 10*time.Second, tracker.Interval())
+	tracker.observeUptime(20)
+	assert.Equal(t, 15*time.Second, tracker.Interval())
+}
+
+func TestSysTracker_Stale(t *testing.T) {
+	labels := prometheus.Labels{"broker": "test-broker"}
+	now := time.Unix(1700000000, 0)
+	tracker := NewSysTracker(labels, SysOptions{StaleIntervals: 3})
+	tracker.now = func() time.Time { return now }
+	collector := NewSysCollector(labels, sysTables["clients"])
+	collector.setSysTracker(tracker)
+	client := newFakeClient("exporter")
+	collector.Subscribe(client)
+
+	registry := prometheus.NewRegistry()
+	require.NoError(t, registry.Register(tracker))
+	require.NoError(t, registry.Register(collector))
+	assert.Equal(t, []float64{0}, gatherValues(t, registry, "mosquitto_sys_data_received"))
+	assert.Empty(t, gatherValues(t, registry, "mosquitto_sys_value_age_seconds"))
+
+	tracker.observeUptime(100)
+	tracker.observeUptime(110)
+	client.publish("$SYS/broker/clients/connected", "0")
+	now = now.Add(20 * time.Second)
+	client.publish("$SYS/broker/clients/total", "4")
+
+	// A zero that was received is exported, values never received are not
+	assert.Equal(t, []float64{0}, gatherValues(t, registry, "mosquitto_connected_clients_count"))
+	assert.Equal(t, []float64{4}, gatherValues(t, registry, "mosquitto_total_clients_count"))
+	assert.Empty(t, gatherValues(t, registry, "mosquitto_active_clients_count"))
+	assert.Equal(t, []float64{20, 0}, gatherValues(t, registry, "mosquitto_sys_value_age_seconds"))
+	assert.Equal(t, []float64{1}, gatherValues(t, registry, "mosquitto_sys_data_received"))
+
+	// Older than 3 intervals of 10s
+	now = now.Add(15 * time.Second)
+	assert.Empty(t, gatherValues(t, registry, "mosquitto_connected_clients_count"))
+	assert.Equal(t, []float64{4}, gatherValues(t, registry, "mosquitto_total_clients_count"))
+	assert.Equal(t, []float64{35, 15}, gatherValues(t, registry, "mosquitto_sys_value_age_seconds"))
+
+	now = now.Add(time.Minute)
+	assert.Empty(t, gatherValues(t, registry, "mosquitto_total_clients_count"))
+	assert.Equal(t, []float64{0}, gatherValues(t, registry, "mosquitto_sys_data_received"))
+}
+
+func TestSysTracker_KeepValues(t *testing.T) {
+	labels := prometheus.Labels{"broker": "test-broker"}
+	now := time.Unix(1700000000, 0)
+	tracker := NewSysTracker(labels, SysOptions{})
+	tracker.now = func() time.Time { return now }
+	collector := NewSysCollector(labels, loadTable(LoadOptions{}))
+	collector.setSysTracker(tracker)
+	client := newFakeClient("exporter")
+	collector.Subscribe(client)
+	registry := registryWith(t, collector)
+
+	tracker.observeUptime(100)
+	tracker.observeUptime(110)
+	client.publish("$SYS/broker/load/connections/1min", "1.5")
+	now = now.Add(time.Hour)
+
+	assert.Equal(t, []float64{1.5}, gatherValues(t, registry, "mosquitto_connections_load1"))
+	assert.Empty(t, gatherValues(t, registry, "mosquitto_connections_load5"))
+	assert.True(t, tracker.fresh(now.Add(-time.Hour)))
+	assert.Error(t, SysOptions{StaleIntervals: -1}.validate())
+}
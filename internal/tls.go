@@ -0,0 +1,184 @@
+package internal
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TLSConfig holds the settings used to secure the connection to the broker.
+type TLSConfig struct {
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	ServerName         string `yaml:"server_name"`
+	MinVersion         string `yaml:"min_version"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+// Enabled reports whether any TLS setting was provided.
+func (c TLSConfig) Enabled() bool {
+	return c.CAFile != "" || c.CertFile != "" || c.KeyFile != "" || c.ServerName != "" || c.MinVersion != "" || c.InsecureSkipVerify
+}
+
+var tlsVersions = map[string]uint16{
+	"TLS10": tls.VersionTLS10,
+	"TLS11": tls.VersionTLS11,
+	"TLS12": tls.VersionTLS12,
+	"TLS13": tls.VersionTLS13,
+	"1.0":   tls.VersionTLS10,
+	"1.1":   tls.VersionTLS11,
+	"1.2":   tls.VersionTLS12,
+	"1.3":   tls.VersionTLS13,
+}
+
+// TLSLoader builds a tls.Config from a TLSConfig and rebuilds it whenever one
+// of the referenced files changes on disk, so rotated certificates are picked
+// up on the next connection attempt without restarting the exporter.
+type TLSLoader struct {
+	cfg      TLSConfig
+	mu       sync.Mutex
+	modTimes map[string]time.Time
+	current  *tls.Config
+}
+
+// NewTLSLoader validates cfg and loads the initial tls.Config.
+func NewTLSLoader(cfg TLSConfig) (*TLSLoader, error) {
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return nil, fmt.Errorf("both a client certificate and a key file must be provided")
+	}
+	if _, err := parseTLSVersion(cfg.MinVersion); err != nil {
+		return nil, err
+	}
+	loader := &TLSLoader{cfg: cfg}
+	if _, err := loader.Config(); err != nil {
+		return nil, err
+	}
+	return loader, nil
+}
+
+// Config returns the current tls.Config, reloading it first if any of the
+// certificate files was modified since the last load. When a reload fails the
+// previous configuration is kept and the error is logged.
+func (l *TLSLoader) Config() (*tls.Config, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	modTimes, err := l.statFiles()
+	if err != nil {
+		if l.current != nil {
+			log.Printf("Failed to check TLS files, keeping previous configuration: %v", err)
+			return l.current, nil
+		}
+		return nil, err
+	}
+	if l.current != nil && sameModTimes(l.modTimes, modTimes) {
+		return l.current, nil
+	}
+
+	config, err := l.build()
+	if err != nil {
+		if l.current != nil {
+			log.Printf("Failed to reload TLS configuration, keeping previous one: %v", err)
+			return l.current, nil
+		}
+		return nil, err
+	}
+	if l.current != nil {
+		log.Println("Reloaded TLS configuration")
+	}
+	l.current = config
+	l.modTimes = modTimes
+	return config, nil
+}
+
+// ConnectionAttemptHandler is meant to be passed to
+// mqtt.ClientOptions.SetConnectionAttemptHandler so each connection attempt
+// uses the latest certificates.
+func (l *TLSLoader) ConnectionAttemptHandler(broker *url.URL, tlsCfg *tls.Config) *tls.Config {
+	config, err := l.Config()
+	if err != nil {
+		log.Printf("Failed to load TLS configuration: %v", err)
+		return tlsCfg
+	}
+	return config
+}
+
+func (l *TLSLoader) files() []string {
+	files := make([]string, 0, 3)
+	for _, file := range []string{l.cfg.CAFile, l.cfg.CertFile, l.cfg.KeyFile} {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+func (l *TLSLoader) statFiles() (map[string]time.Time, error) {
+	modTimes := make(map[string]time.Time, 3)
+	for _, file := range l.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		modTimes[file] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+func (l *TLSLoader) build() (*tls.Config, error) {
+	minVersion, _ := parseTLSVersion(l.cfg.MinVersion)
+	config := &tls.Config{
+		ServerName:         l.cfg.ServerName,
+		MinVersion:         minVersion,
+		InsecureSkipVerify: l.cfg.InsecureSkipVerify,
+	}
+	if l.cfg.CAFile != "" {
+		pem, err := os.ReadFile(l.cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in CA file %s", l.cfg.CAFile)
+		}
+		config.RootCAs = pool
+	}
+	if l.cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(l.cfg.CertFile, l.cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+func parseTLSVersion(version string) (uint16, error) {
+	if version == "" {
+		return tls.VersionTLS12, nil
+	}
+	v, ok := tlsVersions[strings.ToUpper(version)]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version %q", version)
+	}
+	return v, nil
+}
+
+func sameModTimes(a, b map[string]time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for file, t := range a {
+		if !b[file].Equal(t) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,117 @@
+package internal
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestCertificate writes a self-signed certificate and its key to dir
+// and returns their paths.
+func writeTestCertificate(t *testing.T, dir string, commonName string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600))
+	return certFile, keyFile
+}
+
+func TestTLSConfig_Enabled(t *testing.T) {
+	assert.False(t, TLSConfig{}.Enabled())
+	assert.True(t, TLSConfig{InsecureSkipVerify: true}.Enabled())
+	assert.True(t, TLSConfig{CAFile: "ca.pem"}.Enabled())
+}
+
+func TestNewTLSLoader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCertificate(t, dir, "first")
+
+	loader, err := NewTLSLoader(TLSConfig{
+		CAFile:     certFile,
+		CertFile:   certFile,
+		KeyFile:    keyFile,
+		ServerName: "broker.example.com",
+		MinVersion: "TLS13",
+	})
+	require.NoError(t, err)
+
+	config, err := loader.Config()
+	require.NoError(t, err)
+	assert.Equal(t, "broker.example.com", config.ServerName)
+	assert.Equal(t, uint16(tls.VersionTLS13), config.MinVersion)
+	assert.NotNil(t, config.RootCAs)
+	assert.Len(t, config.Certificates, 1)
+}
+
+func TestNewTLSLoader_Errors(t *testing.T) {
+	_, err := NewTLSLoader(TLSConfig{CertFile: "cert.pem"})
+	assert.Error(t, err)
+
+	_, err = NewTLSLoader(TLSConfig{MinVersion: "SSL3"})
+	assert.Error(t, err)
+
+	_, err = NewTLSLoader(TLSConfig{CAFile: filepath.Join(t.TempDir(), "missing.pem")})
+	assert.Error(t, err)
+}
+
+func TestTLSLoader_Reload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCertificate(t, dir, "first")
+
+	loader, err := NewTLSLoader(TLSConfig{CertFile: certFile, KeyFile: keyFile})
+	require.NoError(t, err)
+	first, err := loader.Config()
+	require.NoError(t, err)
+
+	// Unchanged files keep the cached configuration
+	same, err := loader.Config()
+	require.NoError(t, err)
+	assert.Same(t, first, same)
+
+	writeTestCertificate(t, dir, "second")
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, later, later))
+	require.NoError(t, os.Chtimes(keyFile, later, later))
+
+	second := loader.ConnectionAttemptHandler(nil, nil)
+	assert.NotSame(t, first, second)
+	leaf, err := x509.ParseCertificate(second.Certificates[0].Certificate[0])
+	require.NoError(t, err)
+	assert.Equal(t, "second", leaf.Subject.CommonName)
+
+	// A broken rotation keeps the previous configuration
+	require.NoError(t, os.WriteFile(certFile, []byte("garbage"), 0o600))
+	evenLater := later.Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, evenLater, evenLater))
+	third, err := loader.Config()
+	require.NoError(t, err)
+	assert.Same(t, second, third)
+}
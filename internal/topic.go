@@ -0,0 +1,158 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// topicLevels splits a topic into its levels.
+func topicLevels(topic string) []string {
+	return strings.Split(topic, "/")
+}
+
+// validTopicFilter checks the wildcards of an MQTT topic filter: '+' must
+// occupy a whole level and '#' must be the whole last level.
+func validTopicFilter(filter string) error {
+	if filter == "" {
+		return fmt.Errorf("empty topic filter")
+	}
+	levels := topicLevels(filter)
+	for i, level := range levels {
+		if strings.Contains(level, "#") && (level != "#" || i != len(levels)-1) {
+			return fmt.Errorf("invalid topic filter %q: '#' must be the last level", filter)
+		}
+		if strings.Contains(level, "+") && level != "+" {
+			return fmt.Errorf("invalid topic filter %q: '+' must occupy a whole level", filter)
+		}
+	}
+	return nil
+}
+
+// topicMatches reports whether topic matches the MQTT topic filter. As in
+// the MQTT specification, wildcards at the first level do not match topics
+// starting with '$'.
+func topicMatches(filter string, topic string) bool {
+	filterLevels := topicLevels(filter)
+	levels := topicLevels(topic)
+	if strings.HasPrefix(topic, "$") && (filterLevels[0] == "+" || filterLevels[0] == "#") {
+		return false
+	}
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(levels) || (level != "+" && level != levels[i]) {
+			return false
+		}
+	}
+	return len(levels) == len(filterLevels)
+}
+
+// filterWildcards returns the number of wildcards of a topic filter.
+func filterWildcards(filter string) int {
+	wildcards := 0
+	for _, level := range topicLevels(filter) {
+		if level == "+" || level == "#" {
+			wildcards++
+		}
+	}
+	return wildcards
+}
+
+// topicWildcards returns the topic levels matched by the wildcards of filter,
+// '#' matching all the remaining levels.
+func topicWildcards(filter string, topic string) []string {
+	levels := topicLevels(topic)
+	var values []string
+	for i, level := range topicLevels(filter) {
+		switch level {
+		case "+":
+			values = append(values, levels[i])
+		case "#":
+			if i < len(levels) {
+				values = append(values, strings.Join(levels[i:], "/"))
+			} else {
+				values = append(values, "")
+			}
+		}
+	}
+	return values
+}
+
+// firstMatchingFilter returns the index of the first filter matching topic,
+// or -1 if none does.
+func firstMatchingFilter(filters []string, topic string) int {
+	for i, filter := range filters {
+		if topicMatches(filter, topic) {
+			return i
+		}
+	}
+	return -1
+}
+
+// firstFilterHandler returns the handler of the i-th of filters. The client
+// calls the handlers of all the filters matching a topic, so handler is only
+// called when filters[i] is the first of them.
+func firstFilterHandler(filters []string, i int, handler MessageHandler) MessageHandler {
+	return func(client Client, message Message) {
+		if firstMatchingFilter(filters, message.Topic()) != i {
+			return
+		}
+		handler(client, message)
+	}
+}
+
+// wildcardMatchesFirst reports whether the first level of a filter can be
+// matched by the first level of other: wildcards at the first level do not
+// match the levels starting with '$'.
+func wildcardMatchesFirst(filter []string, other []string) bool {
+	wildcard := filter[0] == "+" || filter[0] == "#"
+	return !wildcard || !strings.HasPrefix(other[0], "$")
+}
+
+// filtersOverlap reports whether some topic matches both filters.
+func filtersOverlap(a string, b string) bool {
+	aLevels, bLevels := topicLevels(a), topicLevels(b)
+	if !wildcardMatchesFirst(aLevels, bLevels) || !wildcardMatchesFirst(bLevels, aLevels) {
+		return false
+	}
+	n := min(len(aLevels), len(bLevels))
+	for i := range n {
+		x, y := aLevels[i], bLevels[i]
+		if x == "#" || y == "#" {
+			return true
+		}
+		if x != "+" && y != "+" && x != y {
+			return false
+		}
+	}
+	switch {
+	case len(aLevels) > n:
+		return aLevels[n] == "#"
+	case len(bLevels) > n:
+		return bLevels[n] == "#"
+	}
+	return true
+}
+
+// joinFilters returns the narrowest filter covering two overlapping filters,
+// replacing the levels they differ on by '+' and their extra levels by '#'.
+func joinFilters(a string, b string) string {
+	aLevels, bLevels := topicLevels(a), topicLevels(b)
+	var joined []string
+	for i := range min(len(aLevels), len(bLevels)) {
+		x, y := aLevels[i], bLevels[i]
+		switch {
+		case x == "#" || y == "#":
+			return strings.Join(append(joined, "#"), "/")
+		case x == y:
+			joined = append(joined, x)
+		default:
+			joined = append(joined, "+")
+		}
+	}
+	if len(aLevels) != len(bLevels) {
+		joined = append(joined, "#")
+	}
+	return strings.Join(joined, "/")
+}
@@ -0,0 +1,71 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopicLevels(t *testing.T) {
+	assert.Equal(t, []string{"$SYS", "broker", "retained messages", "count"}, topicLevels("$SYS/broker/retained messages/count"))
+	assert.Equal(t, []string{"$SYS", "broker", "uptime"}, topicLevels("$SYS/broker/uptime"))
+}
+
+func TestValidTopicFilter(t *testing.T) {
+	for _, filter := range []string{"devices/+/telemetry", "devices/#", "#", "+", "a/b/c"} {
+		assert.NoError(t, validTopicFilter(filter), filter)
+	}
+	for _, filter := range []string{"", "devices/#/telemetry", "devices/a#", "devices/a+/b"} {
+		assert.Error(t, validTopicFilter(filter), filter)
+	}
+}
+
+func TestTopicMatches(t *testing.T) {
+	assert.True(t, topicMatches("devices/+/telemetry", "devices/d1/telemetry"))
+	assert.False(t, topicMatches("devices/+/telemetry", "devices/d1/status"))
+	assert.False(t, topicMatches("devices/+/telemetry", "devices/d1/telemetry/extra"))
+	assert.True(t, topicMatches("devices/#", "devices"))
+	assert.True(t, topicMatches("devices/#", "devices/d1/telemetry"))
+	assert.True(t, topicMatches("#", "devices/d1"))
+	assert.False(t, topicMatches("#", "$SYS/broker/uptime"))
+	assert.False(t, topicMatches("+/broker/uptime", "$SYS/broker/uptime"))
+	assert.True(t, topicMatches("$SYS/#", "$SYS/broker/uptime"))
+}
+
+func TestTopicWildcards(t *testing.T) {
+	assert.Equal(t, 2, filterWildcards("devices/+/#"))
+	assert.Equal(t, 0, filterWildcards("devices/d1"))
+	assert.Equal(t, []string{"d1"}, topicWildcards("devices/+/telemetry", "devices/d1/telemetry"))
+	assert.Equal(t, []string{"d1", "a/b"}, topicWildcards("devices/+/#", "devices/d1/a/b"))
+	assert.Equal(t, []string{""}, topicWildcards("sites/#", "sites"))
+}
+
+func TestFirstMatchingFilter(t *testing.T) {
+	filters := []string{"plant/+/heartbeat", "plant/#"}
+	assert.Equal(t, 0, firstMatchingFilter(filters, "plant/p1/heartbeat"))
+	assert.Equal(t, 1, firstMatchingFilter(filters, "plant/p1/status"))
+	assert.Equal(t, -1, firstMatchingFilter(filters, "office/heartbeat"))
+}
+
+func TestFiltersOverlap(t *testing.T) {
+	assert.True(t, filtersOverlap("$SYS/#", "$SYS/broker/log/#"))
+	assert.True(t, filtersOverlap("devices/+/heartbeat", "devices/d1/#"))
+	assert.True(t, filtersOverlap("devices/#", "devices"))
+	assert.True(t, filtersOverlap("devices", "devices/#"))
+	assert.True(t, filtersOverlap("a/+/c", "a/b/+"))
+	assert.False(t, filtersOverlap("devices/+/heartbeat", "devices/+/telemetry"))
+	assert.False(t, filtersOverlap("devices/d1", "devices/d1/status"))
+	assert.False(t, filtersOverlap("#", "$SYS/broker/uptime"))
+	assert.False(t, filtersOverlap("+/broker/uptime", "$SYS/#"))
+}
+
+func TestJoinFilters(t *testing.T) {
+	assert.Equal(t, "$SYS/#", joinFilters("$SYS/#", "$SYS/broker/log/#"))
+	assert.Equal(t, "devices/+/#", joinFilters("devices/+/heartbeat", "devices/d1/#"))
+	assert.Equal(t, "a/+/+", joinFilters("a/+/c", "a/b/+"))
+	assert.Equal(t, "devices/#", joinFilters("devices", "devices/#"))
+	assert.Equal(t, "a/b/#", joinFilters("a/b", "a/b/#"))
+	// A covered filter joins into the covering one
+	assert.Equal(t, "devices/+/heartbeat", joinFilters("devices/d1/heartbeat", "devices/+/heartbeat"))
+	assert.Equal(t, "devices/#", joinFilters("devices/+/heartbeat", "devices/#"))
+}
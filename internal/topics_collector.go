@@ -0,0 +1,180 @@
+package internal
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// otherTopics is the topic label of the messages received once
+// TopicsOptions.MaxSeries is reached.
+const otherTopics = "_other"
+
+// TopicsOptions configures the application topics collector.
+type TopicsOptions struct {
+	// Filters are the topic filters to subscribe to.
+	Filters []string `yaml:"filters"`
+	// Collapse are topic filters whose wildcard levels are kept as wildcards
+	// in the topic label, e.g. 'devices/+/telemetry' exports every device
+	// under a single 'devices/+/telemetry' series.
+	Collapse []string `yaml:"collapse"`
+	// Depth truncates the topics matching no collapse rule to this number
+	// of levels, 0 keeps them whole.
+	Depth int `yaml:"depth"`
+	// MaxSeries caps the number of topic labels, the messages of additional
+	// topics are accounted under '_other'.
+	MaxSeries int `yaml:"max_series"`
+}
+
+func (o TopicsOptions) validate() error {
+	for _, filter := range append(append([]string{}, o.Filters...), o.Collapse...) {
+		if err := validTopicFilter(filter); err != nil {
+			return fmt.Errorf("topics: %w", err)
+		}
+	}
+	if o.Depth < 0 {
+		return fmt.Errorf("topics.depth must not be negative")
+	}
+	if o.MaxSeries < 0 {
+		return fmt.Errorf("topics.max_series must not be negative")
+	}
+	return nil
+}
+
+type topicStats struct {
+	messages float64
+	bytes    float64
+	lastSeen time.Time
+}
+
+// TopicsCollector samples the messages published on application topics and
+// accounts them per collapsed topic.
+type TopicsCollector struct {
+	mu           sync.RWMutex
+	Topics       map[string]*topicStats
+	options      TopicsOptions
+	descriptions map[string]metric
+	payloadSize  *prometheus.HistogramVec
+	now          func() time.Time
+}
+
+func init() {
+	registerCollector("topics", false, "Enable the application topics collector.", func(labels prometheus.Labels, options CollectorOptions) Collector {
+		return NewTopicsCollector(labels, options.Topics)
+	})
+}
+
+func NewTopicsCollector(labels prometheus.Labels, options TopicsOptions) *TopicsCollector {
+	return &TopicsCollector{
+		mu:      sync.RWMutex{},
+		Topics:  make(map[string]*topicStats, 16),
+		options: options,
+		descriptions: map[string]metric{
+			"messages": {
+				desc:      prometheus.NewDesc("mosquitto_topic_messages_total", "Total number of messages received on a topic", []string{"topic"}, labels),
+				valueType: prometheus.CounterValue,
+			},
+			"bytes": {
+				desc:      prometheus.NewDesc("mosquitto_topic_payload_bytes_total", "Total size of the payloads received on a topic", []string{"topic"}, labels),
+				valueType: prometheus.CounterValue,
+			},
+			"age": {
+				desc:      prometheus.NewDesc("mosquitto_topic_last_message_age_seconds", "Seconds since the last message was received on a topic", []string{"topic"}, labels),
+				valueType: prometheus.GaugeValue,
+			},
+		},
+		payloadSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:        "mosquitto_topic_payload_size_bytes",
+			Help:        "Size of the payloads received on a topic",
+			ConstLabels: labels,
+			Buckets:     prometheus.ExponentialBuckets(16, 4, 8),
+		}, []string{"topic"}),
+		now: time.Now,
+	}
+}
+
+func (collector *TopicsCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range collector.descriptions {
+		ch <- desc.desc
+	}
+	collector.payloadSize.Describe(ch)
+}
+
+func (collector *TopicsCollector) Collect(ch chan<- prometheus.Metric) {
+	collector.payloadSize.Collect(ch)
+
+	collector.mu.RLock()
+	defer collector.mu.RUnlock()
+	messages := collector.descriptions["messages"]
+	bytes := collector.descriptions["bytes"]
+	age := collector.descriptions["age"]
+	now := collector.now()
+	for topic, stats := range collector.Topics {
+		ch <- prometheus.MustNewConstMetric(messages.desc, messages.valueType, stats.messages, topic)
+		ch <- prometheus.MustNewConstMetric(bytes.desc, bytes.valueType, stats.bytes, topic)
+		ch <- prometheus.MustNewConstMetric(age.desc, age.valueType, now.Sub(stats.lastSeen).Seconds(), topic)
+	}
+}
+
+func (collector *TopicsCollector) Subscribe(client Client) {
+	for i, filter := range collector.options.Filters {
+		if err := client.Subscribe(filter, 0, firstFilterHandler(collector.options.Filters, i, collector.messageHandler)); err != nil {
+			log.Printf("Failed to subscribe to %s: %v", filter, err)
+		}
+	}
+}
+
+func (collector *TopicsCollector) messageHandler(client Client, message Message) {
+	topic := collector.collapse(message.Topic())
+	size := float64(len(message.Payload()))
+
+	collector.mu.Lock()
+	stats, ok := collector.Topics[topic]
+	if !ok {
+		if collector.options.MaxSeries > 0 && len(collector.Topics) >= collector.options.MaxSeries {
+			topic = otherTopics
+			stats = collector.Topics[topic]
+		}
+		if stats == nil {
+			stats = &topicStats{}
+			collector.Topics[topic] = stats
+		}
+	}
+	stats.messages++
+	stats.bytes += size
+	stats.lastSeen = collector.now()
+	collector.mu.Unlock()
+
+	collector.payloadSize.WithLabelValues(topic).Observe(size)
+}
+
+// collapse returns the topic label of topic: the first matching collapse rule
+// with the topic levels in place of its non wildcard levels, or the topic
+// truncated to the configured depth.
+func (collector *TopicsCollector) collapse(topic string) string {
+	levels := topicLevels(topic)
+	for _, rule := range collector.options.Collapse {
+		if !topicMatches(rule, topic) {
+			continue
+		}
+		ruleLevels := topicLevels(rule)
+		for i, level := range ruleLevels {
+			if level == "#" {
+				levels = append(levels[:i], "#")
+				break
+			}
+			if level == "+" {
+				levels[i] = "+"
+			}
+		}
+		return strings.Join(levels, "/")
+	}
+	if depth := collector.options.Depth; depth > 0 && len(levels) > depth {
+		return strings.Join(append(levels[:depth], "#"), "/")
+	}
+	return topic
+}
@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewTopicsCollector(t *testing.T) {
+	labels := prometheus.Labels{"broker": "test-broker"}
+	collector := NewTopicsCollector(labels, TopicsOptions{})
+
+	assert.NotNil(t, collector)
+	assert.NotNil(t, collector.Topics)
+	assert.Equal(t, 3, len(collector.descriptions))
+}
+
+func TestTopicsCollector_Collapse(t *testing.T) {
+	labels := prometheus.Labels{"broker": "test-broker"}
+	collector := NewTopicsCollector(labels, TopicsOptions{
+		Collapse: []string{"devices/+/telemetry", "alerts/+/#"},
+		Depth:    2,
+	})
+
+	assert.Equal(t, "devices/+/telemetry", collector.collapse("devices/d1/telemetry"))
+	assert.Equal(t, "alerts/+/#", collector.collapse("alerts/fire/room/12"))
+	assert.Equal(t, "alerts/+/#", collector.collapse("alerts/fire"))
+	assert.Equal(t, "devices/d1/#", collector.collapse("devices/d1/status/battery"))
+	assert.Equal(t, "home/kitchen", collector.collapse("home/kitchen"))
+}
+
+func TestTopicsCollector_MessageHandler(t *testing.T) {
+	labels := prometheus.Labels{"broker": "test-broker"}
+	collector := NewTopicsCollector(labels, TopicsOptions{
+		Filters:   []string{"devices/#", "devices/+/telemetry"},
+		Collapse:  []string{"devices/+/telemetry"},
+		MaxSeries: 2,
+	})
+	now := time.Now()
+	collector.now = func() time.Time { return now }
+	registry := registryWith(t, collector)
+	client := newFakeClient("exporter")
+	collector.Subscribe(client)
+
+	// The message matches both filters but is only accounted once
+	client.publish("devices/d1/telemetry", `{"t":21.5}`)
+	client.publish("devices/d2/telemetry", `{"t":19}`)
+	client.publish("devices/d1/status", "online")
+	// The limit is reached
+	client.publish("devices/d1/battery", "87")
+	client.publish("devices/d2/battery", "90")
+	// Not subscribed
+	client.publish("sites/paris/gateway", "online")
+
+	require.Len(t, collector.Topics, 3)
+	assert.Equal(t, float64(2), collector.Topics["devices/+/telemetry"].messages)
+	assert.Equal(t, float64(18), collector.Topics["devices/+/telemetry"].bytes)
+	assert.Equal(t, float64(1), collector.Topics["devices/d1/status"].messages)
+	assert.Equal(t, float64(2), collector.Topics[otherTopics].messages)
+
+	now = now.Add(10 * time.Second)
+	assert.Equal(t, []float64{10, 10, 10}, gatherValues(t, registry, "mosquitto_topic_last_message_age_seconds"))
+
+	assert.Error(t, TopicsOptions{Filters: []string{"devices/#/x"}}.validate())
+	assert.Error(t, TopicsOptions{Collapse: []string{"a+"}}.validate())
+	assert.Error(t, TopicsOptions{Depth: -1}.validate())
+}
@@ -4,17 +4,17 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"time"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 
 	"github.com/alecthomas/kingpin"
-	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/qaoru/mosquitto_exporter/internal"
 )
 
-
-
 var (
 	version = "dev"
 	commit  = "none"
@@ -26,77 +26,109 @@ var (
 	webListenAddress = kingpin.Flag("web.listen-address", "Address on which the web server will listen.").Default(":9344").String()
 	webTelemetryPath = kingpin.Flag("web.telemetry-path", "Path on which metrics will be served.").Default("/metrics").String()
 
-	broker            = kingpin.Flag("mqtt.broker", "Broker connection string.").Short('b').Default("tcp://127.0.0.1:1883").Envar("MQTT_BROKER").String()
-	clientID          = kingpin.Flag("mqtt.client-id", "Client ID to use when connected to the broker.").Default("mosquitto-exporter").Envar("MQTT_CLIENT_ID").String()
-	username          = kingpin.Flag("mqtt.username", "Broker username").Short('u').Envar("MQTT_USERNAME").String()
-	password          = kingpin.Flag("mqtt.password", "Broker password").Short('p').Envar("MQTT_PASSWORD").String()
-	clientsCollector  = kingpin.Flag("collector.clients", "Enable the clients collector.").Bool()
-	messagesCollector = kingpin.Flag("collector.messages", "Enable the messages collector.").Bool()
-	loadCollector     = kingpin.Flag("collector.load", "Enable the load collector.").Bool()
+	broker               = kingpin.Flag("mqtt.broker", "Broker connection string.").Short('b').Default("tcp://127.0.0.1:1883").Envar("MQTT_BROKER").String()
+	clientID             = kingpin.Flag("mqtt.client-id", "Client ID to use when connected to the broker.").Default("mosquitto-exporter").Envar("MQTT_CLIENT_ID").String()
+	username             = kingpin.Flag("mqtt.username", "Broker username").Short('u').Envar("MQTT_USERNAME").String()
+	password             = kingpin.Flag("mqtt.password", "Broker password").Short('p').Envar("MQTT_PASSWORD").String()
+	tlsCAFile            = kingpin.Flag("mqtt.tls.ca-file", "CA certificate bundle used to verify the broker certificate.").Envar("MQTT_TLS_CA_FILE").String()
+	tlsCertFile          = kingpin.Flag("mqtt.tls.cert-file", "Client certificate file for mutual TLS.").Envar("MQTT_TLS_CERT_FILE").String()
+	tlsKeyFile           = kingpin.Flag("mqtt.tls.key-file", "Client private key file for mutual TLS.").Envar("MQTT_TLS_KEY_FILE").String()
+	tlsServerName        = kingpin.Flag("mqtt.tls.server-name", "Override the server name used to verify the broker certificate.").Envar("MQTT_TLS_SERVER_NAME").String()
+	tlsMinVersion        = kingpin.Flag("mqtt.tls.min-version", "Minimum TLS version (TLS10, TLS11, TLS12, TLS13).").Envar("MQTT_TLS_MIN_VERSION").String()
+	tlsInsecure          = kingpin.Flag("mqtt.tls.insecure-skip-verify", "Disable verification of the broker certificate.").Envar("MQTT_TLS_INSECURE_SKIP_VERIFY").Bool()
+	protocolVersion      = kingpin.Flag("mqtt.protocol-version", "MQTT protocol version (3.1, 3.1.1 or 5), 3.1.1 or 3.1 is negotiated when unset.").Envar("MQTT_PROTOCOL_VERSION").String()
+	sessionExpiry        = kingpin.Flag("mqtt.session-expiry", "MQTT v5 session expiry interval.").Envar("MQTT_SESSION_EXPIRY").Duration()
+	userProperties       = kingpin.Flag("mqtt.user-property", "MQTT v5 user property sent on connection as NAME=VALUE, can be repeated.").StringMap()
+	authMethod           = kingpin.Flag("mqtt.auth-method", "MQTT v5 enhanced authentication method.").Envar("MQTT_AUTH_METHOD").String()
+	authData             = kingpin.Flag("mqtt.auth-data", "MQTT v5 enhanced authentication data.").Envar("MQTT_AUTH_DATA").String()
+	collectorFlags       = registerCollectorFlags(kingpin.CommandLine)
+	loadIntervals        = kingpin.Flag("collector.load.intervals", "Moving averages exported by the load collector (1min, 5min, 15min), can be repeated. Defaults to all of them.").Strings()
+	bridgeStaleAfter     = kingpin.Flag("collector.bridge.stale-after", "Drop bridges whose state was not published for this long, only for brokers republishing it (0 to keep them forever).").Default("0s").Duration()
+	connectionsInfo      = kingpin.Flag("collector.connections.info-metrics", "Export one series per connected client.").Bool()
+	connectionsMaxSeries = kingpin.Flag("collector.connections.max-series", "Maximum number of clients exported as series (0 for no limit).").Default("1000").Int()
+	connectionsAllow     = kingpin.Flag("collector.connections.allow", "Only export clients whose ID matches this regular expression.").String()
+	connectionsDeny      = kingpin.Flag("collector.connections.deny", "Do not export clients whose ID matches this regular expression.").String()
+	dynsecInterval       = kingpin.Flag("collector.dynsec.interval", "Interval between two listings of the dynamic security plugin.").Default("1m").Duration()
+	controlInterval      = kingpin.Flag("collector.control.interval", "Interval between two listings of the broker control API.").Default("1m").Duration()
+	roundtripTopic       = kingpin.Flag("collector.roundtrip.topic", "Topic prefix of the round-trip probe messages, one subtopic is used per QoS.").Default("mosquitto_exporter/roundtrip").String()
+	roundtripInterval    = kingpin.Flag("collector.roundtrip.interval", "Interval between two round-trip probes.").Default("15s").Duration()
+	roundtripTimeout     = kingpin.Flag("collector.roundtrip.timeout", "Time after which a round-trip probe message is considered lost.").Default("5s").Duration()
+	persistenceTopic     = kingpin.Flag("collector.persistence.topic", "Topic of the retained probe message (defaults to mosquitto_exporter/retained/<client ID>).").String()
+	persistenceInterval  = kingpin.Flag("collector.persistence.interval", "Interval between two retained message probes.").Default("1m").Duration()
+	persistenceTimeout   = kingpin.Flag("collector.persistence.timeout", "Timeout of every step of a retained message probe.").Default("5s").Duration()
+	persistenceRestarts  = kingpin.Flag("collector.persistence.check-restarts", "Verify the retained probe message survives broker restarts.").Bool()
+	topicsFilters        = kingpin.Flag("collector.topics.filter", "Application topic filter to subscribe to, can be repeated.").Strings()
+	topicsCollapse       = kingpin.Flag("collector.topics.collapse", "Topic filter whose wildcard levels are kept as wildcards in the topic label, can be repeated.").Strings()
+	topicsDepth          = kingpin.Flag("collector.topics.depth", "Truncate the topics matching no collapse rule to this number of levels (0 to keep them whole).").Default("0").Int()
+	topicsMaxSeries      = kingpin.Flag("collector.topics.max-series", "Maximum number of topic labels, other topics are accounted under '_other' (0 for no limit).").Default("1000").Int()
+	livenessWatches      = livenessWatchList(kingpin.Flag("collector.liveness.watch", "Topic filter and threshold as FILTER=THRESHOLD, e.g. 'plant/+/heartbeat=5m', can be repeated."))
+	livenessMaxTopics    = kingpin.Flag("collector.liveness.max-topics", "Maximum number of topics tracked individually, other topics are tracked under their filter (0 for no limit).").Default("1000").Int()
+	discoveryAllow       = kingpin.Flag("collector.discovery.allow", "Only export the $SYS topics matching this regular expression.").String()
+	discoveryDeny        = kingpin.Flag("collector.discovery.deny", "Do not export the $SYS topics matching this regular expression.").String()
+	discoveryMaxSeries   = kingpin.Flag("collector.discovery.max-series", "Maximum number of discovered $SYS values (0 for no limit).").Default("1000").Int()
+	sysStaleIntervals    = kingpin.Flag("collector.sys.stale-intervals", "Number of $SYS intervals after which the $SYS values that were not published again are no longer exported (0 to keep them).").Default("0").Int()
 
-	constLabels = make(prometheus.Labels, 4)
+	configFile = kingpin.Flag("config.file", "Path to a YAML configuration file listing the brokers and modules. Overrides the --mqtt.* and --collector.* flags. Reloaded on SIGHUP or POST /-/reload.").Envar("CONFIG_FILE").String()
+)
+
+var (
+	configReloadSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "mosquitto_exporter_config_last_reload_successful",
+		Help: "Whether the last configuration reload attempt was successful",
+	})
+	configReloadSeconds = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "mosquitto_exporter_config_last_reload_success_timestamp_seconds",
+		Help: "Timestamp of the last successful configuration reload",
+	})
 )
 
 func main() {
 	kingpin.CommandLine.HelpFlag.Short('h')
 	kingpin.Version(fmt.Sprintf("%s (commit %s, built %s by %s)", version, commit, date, builtBy))
 	kingpin.Parse()
-	mqttOptions := mqtt.NewClientOptions().AddBroker(*broker)
-	constLabels["broker"] = *broker
-	mqttOptions.SetClientID(*clientID)
-	mqttOptions.SetAutoReconnect(true)
-	mqttOptions.SetConnectRetry(true)
-	mqttOptions.SetResumeSubs(true)
-	mqttOptions.SetCleanSession(false)
-	mqttOptions.SetMaxReconnectInterval(30 * time.Second)
-	mqttOptions.SetConnectTimeout(5 * time.Second)
-	if username != nil {
-		mqttOptions.SetUsername(*username)
-	}
-	if password != nil {
-		mqttOptions.SetPassword(*password)
-	}
-
-	// Create up collector and register it
-	upCollector := internal.NewUpCollector(constLabels)
-	prometheus.MustRegister(upCollector)
 
-	// Set up connection handlers
-	mqttOptions.SetOnConnectHandler(func(client mqtt.Client) {
-		log.Println("Connected to broker")
-		upCollector.SetUp(true)
-	})
-	mqttOptions.SetConnectionLostHandler(func(client mqtt.Client, err error) {
-		log.Printf("Connection lost: %v", err)
-		upCollector.SetUp(false)
-	})
-
-	client := mqtt.NewClient(mqttOptions)
-	client.Connect()
-	log.Println("Attempting to connect to broker (async)")
-	// Connection result will be handled by OnConnectHandler and ConnectionLostHandler
+	exporter := internal.NewExporter(prometheus.DefaultRegisterer)
+	defer exporter.Close()
+	probeHandler := internal.NewProbeHandler(nil)
 
-	defer client.Disconnect(250)
-
-	if *clientsCollector {
-		clientsCollector := internal.NewClientsCollector(constLabels)
-		clientsCollector.Subscribe(client)
-		prometheus.MustRegister(clientsCollector)
-	}
-	if *messagesCollector {
-		messagesCollector := internal.NewMessagesCollector(constLabels)
-		messagesCollector.Subscribe(client)
-		prometheus.MustRegister(messagesCollector)
+	if *configFile != "" {
+		prometheus.MustRegister(configReloadSuccess, configReloadSeconds)
+		if err := reloadConfig(exporter, probeHandler); err != nil {
+			log.Fatalf("Failed to load configuration: %v", err)
+		}
+		reloadCh := make(chan chan error)
+		go func() {
+			hup := make(chan os.Signal, 1)
+			signal.Notify(hup, syscall.SIGHUP)
+			for {
+				select {
+				case <-hup:
+					if err := reloadConfig(exporter, probeHandler); err != nil {
+						log.Printf("Failed to reload configuration: %v", err)
+					}
+				case errCh := <-reloadCh:
+					errCh <- reloadConfig(exporter, probeHandler)
+				}
+			}
+		}()
+		http.HandleFunc("/-/reload", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				http.Error(w, "This endpoint requires a POST request", http.StatusMethodNotAllowed)
+				return
+			}
+			errCh := make(chan error)
+			reloadCh <- errCh
+			if err := <-errCh; err != nil {
+				http.Error(w, fmt.Sprintf("Failed to reload configuration: %v", err), http.StatusInternalServerError)
+			}
+		})
+	} else {
+		brokerConfig := flagBrokerConfig()
+		if err := exporter.ApplyConfig([]internal.BrokerConfig{brokerConfig}); err != nil {
+			log.Fatalf("Failed to set up broker: %v", err)
+		}
+		probeHandler.SetModules(map[string]internal.ModuleConfig{"default": brokerConfig.ModuleConfig})
 	}
-	if *loadCollector {
-		loadCollector := internal.NewLoadCollector(constLabels)
-		loadCollector.Subscribe(client)
-		prometheus.MustRegister(loadCollector)
-	}
-
-	defaultCollector := internal.NewDefaultCollector(constLabels)
-	defaultCollector.Subscribe(client)
-	prometheus.MustRegister(defaultCollector)
 
 	// Health endpoint
 	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
@@ -104,6 +136,152 @@ func main() {
 		w.Write([]byte("ok"))
 	})
 	http.Handle(*webTelemetryPath, promhttp.Handler())
+	http.Handle("/probe", probeHandler)
+	http.HandleFunc("/clients", exporter.ServeClients)
 	log.Printf("Starting server on %s", *webListenAddress)
 	http.ListenAndServe(*webListenAddress, nil)
 }
+
+// reloadConfig loads the configuration file and applies it. Only the brokers
+// whose settings changed are reconnected.
+func reloadConfig(exporter *internal.Exporter, probeHandler *internal.ProbeHandler) error {
+	config, err := internal.LoadConfig(*configFile)
+	if err == nil {
+		err = exporter.ApplyConfig(config.Brokers)
+	}
+	if err != nil {
+		configReloadSuccess.Set(0)
+		return err
+	}
+	probeHandler.SetModules(config.Modules)
+	configReloadSuccess.Set(1)
+	configReloadSeconds.SetToCurrentTime()
+	log.Printf("Loaded configuration file %s", *configFile)
+	return nil
+}
+
+// registerCollectorFlags declares a --collector.<name> flag, negated by
+// --no-collector.<name>, for every registered collector that has a help text.
+func registerCollectorFlags(app *kingpin.Application) map[string]*bool {
+	flags := make(map[string]*bool)
+	for _, collector := range internal.Collectors() {
+		if collector.Help == "" {
+			continue
+		}
+		flags[collector.Name] = app.Flag("collector."+collector.Name, collector.Help).Default(strconv.FormatBool(collector.DefaultEnabled)).Bool()
+	}
+	return flags
+}
+
+// flagBrokerConfig builds the configuration of the single broker defined by
+// the command-line flags.
+func flagBrokerConfig() internal.BrokerConfig {
+	brokerConfig := internal.BrokerConfig{
+		URL: *broker,
+		ModuleConfig: internal.ModuleConfig{
+			ClientID: *clientID,
+			Username: *username,
+			Password: *password,
+			TLS: internal.TLSConfig{
+				CAFile:             *tlsCAFile,
+				CertFile:           *tlsCertFile,
+				KeyFile:            *tlsKeyFile,
+				ServerName:         *tlsServerName,
+				MinVersion:         *tlsMinVersion,
+				InsecureSkipVerify: *tlsInsecure,
+			},
+			ProtocolVersion: *protocolVersion,
+			SessionExpiry:   *sessionExpiry,
+			UserProperties:  *userProperties,
+			AuthMethod:      *authMethod,
+			AuthData:        *authData,
+			Options: &internal.CollectorOptions{
+				Load:   internal.LoadOptions{Intervals: *loadIntervals},
+				Bridge: internal.BridgeOptions{StaleAfter: *bridgeStaleAfter},
+				Connections: internal.ConnectionsOptions{
+					InfoMetrics: *connectionsInfo,
+					MaxSeries:   *connectionsMaxSeries,
+					RegexpFilter: internal.RegexpFilter{
+						Allow: *connectionsAllow,
+						Deny:  *connectionsDeny,
+					},
+				},
+				Dynsec:  internal.DynsecOptions{Interval: *dynsecInterval},
+				Control: internal.ControlOptions{Interval: *controlInterval},
+				Roundtrip: internal.RoundtripOptions{
+					Topic:    *roundtripTopic,
+					Interval: *roundtripInterval,
+					Timeout:  *roundtripTimeout,
+				},
+				Persistence: internal.PersistenceOptions{
+					Topic:         *persistenceTopic,
+					Interval:      *persistenceInterval,
+					Timeout:       *persistenceTimeout,
+					CheckRestarts: *persistenceRestarts,
+				},
+				Topics: internal.TopicsOptions{
+					Filters:   *topicsFilters,
+					Collapse:  *topicsCollapse,
+					Depth:     *topicsDepth,
+					MaxSeries: *topicsMaxSeries,
+				},
+				Liveness: internal.LivenessOptions{
+					Watches:   *livenessWatches,
+					MaxTopics: *livenessMaxTopics,
+				},
+				Discovery: internal.DiscoveryOptions{
+					RegexpFilter: internal.RegexpFilter{
+						Allow: *discoveryAllow,
+						Deny:  *discoveryDeny,
+					},
+					MaxSeries: *discoveryMaxSeries,
+				},
+				Sys: internal.SysOptions{StaleIntervals: *sysStaleIntervals},
+			},
+		},
+	}
+	brokerConfig.Collectors, brokerConfig.DisabledCollectors = flagCollectors(collectorFlags)
+	return brokerConfig
+}
+
+// flagCollectors returns the collectors enabled and disabled by the collector
+// flags, compared to their default state.
+func flagCollectors(flags map[string]*bool) (collectors []string, disabled []string) {
+	for _, collector := range internal.Collectors() {
+		enabled, ok := flags[collector.Name]
+		switch {
+		case !ok:
+		case *enabled && !collector.DefaultEnabled:
+			collectors = append(collectors, collector.Name)
+		case !*enabled && collector.DefaultEnabled:
+			disabled = append(disabled, collector.Name)
+		}
+	}
+	return collectors, disabled
+}
+
+// livenessWatchValue is a repeatable flag of liveness watches.
+type livenessWatchValue []internal.LivenessWatch
+
+func (w *livenessWatchValue) Set(value string) error {
+	watch, err := internal.ParseLivenessWatch(value)
+	if err != nil {
+		return err
+	}
+	*w = append(*w, watch)
+	return nil
+}
+
+func (w *livenessWatchValue) String() string {
+	return fmt.Sprint(*w)
+}
+
+func (w *livenessWatchValue) IsCumulative() bool {
+	return true
+}
+
+func livenessWatchList(s kingpin.Settings) *[]internal.LivenessWatch {
+	target := new([]internal.LivenessWatch)
+	s.SetValue((*livenessWatchValue)(target))
+	return target
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"testing"
 
+	"github.com/alecthomas/kingpin"
 	"github.com/qaoru/mosquitto_exporter/internal"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
@@ -25,12 +26,50 @@ func TestMainFunctionality(t *testing.T) {
 	defaultCollector := internal.NewDefaultCollector(constLabels)
 	assert.NotNil(t, defaultCollector)
 
-	clientsCollector := internal.NewClientsCollector(constLabels)
-	assert.NotNil(t, clientsCollector)
+	sysCollector := internal.NewSysCollector(constLabels, internal.SysTable{
+		Mappings: []internal.SysMapping{{Topic: "$SYS/broker/clients/connected", Metric: "mosquitto_connected_clients_count"}},
+	})
+	assert.NotNil(t, sysCollector)
 
-	messagesCollector := internal.NewMessagesCollector(constLabels)
-	assert.NotNil(t, messagesCollector)
+	bridgeCollector := internal.NewBridgeCollector(constLabels, internal.BridgeOptions{})
+	assert.NotNil(t, bridgeCollector)
 
-	loadCollector := internal.NewLoadCollector(constLabels)
-	assert.NotNil(t, loadCollector)
-}
\ No newline at end of file
+	logCollector := internal.NewLogCollector(constLabels)
+	assert.NotNil(t, logCollector)
+
+	connectionsCollector := internal.NewConnectionsCollector(constLabels, internal.ConnectionsOptions{})
+	assert.NotNil(t, connectionsCollector)
+
+	dynsecCollector := internal.NewDynsecCollector(constLabels, internal.DynsecOptions{})
+	assert.NotNil(t, dynsecCollector)
+
+	controlCollector := internal.NewControlCollector(constLabels, internal.ControlOptions{})
+	assert.NotNil(t, controlCollector)
+
+	roundtripCollector := internal.NewRoundtripCollector(constLabels, internal.RoundtripOptions{})
+	assert.NotNil(t, roundtripCollector)
+
+	persistenceCollector := internal.NewPersistenceCollector(constLabels, internal.PersistenceOptions{})
+	assert.NotNil(t, persistenceCollector)
+
+	topicsCollector := internal.NewTopicsCollector(constLabels, internal.TopicsOptions{})
+	assert.NotNil(t, topicsCollector)
+
+	livenessCollector := internal.NewLivenessCollector(constLabels, internal.LivenessOptions{})
+	assert.NotNil(t, livenessCollector)
+
+	payloadCollector := internal.NewPayloadCollector(constLabels, internal.PayloadOptions{})
+	assert.NotNil(t, payloadCollector)
+}
+
+func TestFlagCollectors(t *testing.T) {
+	// A separate application leaves the global flags untouched
+	app := kingpin.New("mosquitto_exporter", "")
+	flags := registerCollectorFlags(app)
+	_, err := app.Parse([]string{"--collector.clients", "--no-collector.default"})
+	assert.NoError(t, err)
+
+	collectors, disabled := flagCollectors(flags)
+	assert.Equal(t, []string{"clients"}, collectors)
+	assert.Equal(t, []string{"default"}, disabled)
+}